@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/macie/boludo/llama"
+)
+
+// BatchItem represents a single prompt of the batch.
+type BatchItem struct {
+	ID     string `json:"id"`
+	Prompt string `json:"prompt"`
+}
+
+// BatchResult represents an answer (or an error) for a single prompt of the
+// batch.
+type BatchResult struct {
+	ID     string `json:"id"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ReadBatchJSONL reads batch items from JSONL (one item per line). IDs can
+// be strings or numbers, and items without ID are identified by the line
+// number.
+func ReadBatchJSONL(r io.Reader) ([]BatchItem, error) {
+	var items []BatchItem
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var item struct {
+			ID     interface{} `json:"id"`
+			Prompt string      `json:"prompt"`
+		}
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.UseNumber()
+		if err := dec.Decode(&item); err != nil {
+			return nil, fmt.Errorf("invalid batch item in line %d: %w", n, err)
+		}
+		var id string
+		switch v := item.ID.(type) {
+		case nil:
+		case string:
+			id = v
+		case json.Number:
+			id = v.String()
+		default:
+			return nil, fmt.Errorf("invalid batch item in line %d: id must be a string or a number", n)
+		}
+		if id == "" {
+			id = strconv.Itoa(n)
+		}
+		items = append(items, BatchItem{ID: id, Prompt: item.Prompt})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read batch items: %w", err)
+	}
+	return items, nil
+}
+
+// ReadBatchFiles reads batch items from files (one item per file). Items are
+// identified by the file names, so files with the same name (e.g. from
+// different directories) are rejected.
+func ReadBatchFiles(paths []string) ([]BatchItem, error) {
+	items := make([]BatchItem, len(paths))
+	seen := make(map[string]string, len(paths))
+	for i, path := range paths {
+		id := filepath.Base(path)
+		if prev, ok := seen[id]; ok {
+			return nil, fmt.Errorf("could not read batch item: '%s' and '%s' have the same name", prev, path)
+		}
+		seen[id] = path
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read batch item: %w", err)
+		}
+		items[i] = BatchItem{ID: id, Prompt: strings.Trim(string(content), "\n")}
+	}
+	return items, nil
+}
+
+// Batch represents completions of many independent prompts.
+type Batch struct {
+	// Prompt specifies the initial prompt (format, system prompt) extended
+	// by each item.
+	Prompt llama.Prompt
+
+	// Prefix specifies an optional text added before each item.
+	Prefix string
+
+	// Jobs specifies the maximum number of items processed at the same time.
+	// If less than 1, items are processed one by one.
+	Jobs int
+
+	// Complete specifies a function which returns completion for the prompt.
+	Complete func(context.Context, llama.Prompt) (llama.Completion, error)
+
+	// Validate specifies an optional function which checks the answer.
+	Validate func(answer string) error
+}
+
+// Run completes all items and passes the results to write in the order of
+// items. Failed items are reported in the results, so Run returns error only
+// if write fails or the context is cancelled.
+func (b Batch) Run(ctx context.Context, items []BatchItem, write func(BatchResult) error) error {
+	jobs := max(b.Jobs, 1)
+	results := make([]chan BatchResult, len(items))
+	for i := range results {
+		results[i] = make(chan BatchResult, 1)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	queue := make(chan int)
+	wg := sync.WaitGroup{}
+	for j := 0; j < jobs; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] <- b.complete(ctx, items[i])
+			}
+		}()
+	}
+	go func() {
+		defer close(queue)
+		for i := range items {
+			select {
+			case queue <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	defer func() {
+		// stop remaining items
+		cancel()
+		wg.Wait()
+	}()
+
+	for i := range items {
+		select {
+		case result := <-results[i]:
+			if err := write(result); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// complete returns the result for a single item.
+func (b Batch) complete(ctx context.Context, item BatchItem) BatchResult {
+	userPrompt := item.Prompt
+	if b.Prefix != "" {
+		userPrompt = fmt.Sprintf("%s %s", b.Prefix, userPrompt)
+	}
+	prompt := b.Prompt
+	prompt.Messages = slices.Clone(prompt.Messages)
+	prompt.Add(userPrompt)
+
+	completion, err := b.Complete(ctx, prompt)
+	if err != nil {
+		return BatchResult{ID: item.ID, Error: err.Error()}
+	}
+	if b.Validate != nil {
+		if err := b.Validate(completion.Text); err != nil {
+			return BatchResult{ID: item.ID, Output: completion.Text, Error: err.Error()}
+		}
+	}
+	return BatchResult{ID: item.ID, Output: completion.Text}
+}
+
+// JSONLWriter returns a function which writes results as JSONL.
+func JSONLWriter(w io.Writer) func(BatchResult) error {
+	enc := json.NewEncoder(w)
+	return func(result BatchResult) error {
+		if err := enc.Encode(result); err != nil {
+			return fmt.Errorf("could not write batch result: %w", err)
+		}
+		return nil
+	}
+}
+
+// CheckDirNames checks if DirWriter writes results of the items to separate
+// files, so the collisions are reported before the items are processed.
+func CheckDirNames(items []BatchItem) error {
+	seen := make(map[string]string, len(items))
+	for _, item := range items {
+		name := filepath.Base(item.ID)
+		if name == "." || name == ".." || name == string(filepath.Separator) {
+			return fmt.Errorf("invalid batch item: '%s' is not a valid file name", item.ID)
+		}
+		if prev, ok := seen[name]; ok {
+			return fmt.Errorf("invalid batch items: '%s' and '%s' are written to the same file '%s'", prev, item.ID, name)
+		}
+		seen[name] = item.ID
+	}
+	return nil
+}
+
+// DirWriter returns a function which writes results to files in dir (named
+// after items). Failed items are skipped. Results with the same file name are
+// reported as error instead of overwriting each other (see: CheckDirNames).
+func DirWriter(dir string) func(BatchResult) error {
+	written := make(map[string]bool)
+	return func(result BatchResult) error {
+		if result.Error != "" {
+			return nil
+		}
+		name := filepath.Base(result.ID)
+		if written[name] {
+			return fmt.Errorf("could not write batch result: duplicated file name '%s'", name)
+		}
+		written[name] = true
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(result.Output), 0o644); err != nil {
+			return fmt.Errorf("could not write batch result: %w", err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/macie/boludo/llama"
+)
+
+func TestReadBatchJSONL(t *testing.T) {
+	input := `{"id": "a", "prompt": "Hi"}
+
+{"prompt": "How are you?"}
+{"id": 7, "prompt": "Bye"}
+`
+	got, err := ReadBatchJSONL(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ReadBatchJSONL() returns error: %v", err)
+	}
+	want := []BatchItem{{ID: "a", Prompt: "Hi"}, {ID: "3", Prompt: "How are you?"}, {ID: "7", Prompt: "Bye"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ReadBatchJSONL() = %v, want %v", got, want)
+	}
+
+	for _, input := range []string{"Hi\n", `{"id": ["a"], "prompt": "Hi"}`} {
+		if _, err := ReadBatchJSONL(strings.NewReader(input)); err == nil {
+			t.Fatalf("ReadBatchJSONL(%q) does not return error", input)
+		}
+	}
+}
+
+func TestBatchRun(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	batch := Batch{
+		Prompt: llama.Prompt{Format: "chatml", System: "Be brief."},
+		Prefix: "Fix:",
+		Jobs:   2,
+		Complete: func(_ context.Context, p llama.Prompt) (llama.Completion, error) {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			if len(p.Messages) != 1 {
+				return llama.Completion{}, errors.New("prompt contains other items")
+			}
+			user := p.Messages[0].Content
+			if user == "Fix: fail" {
+				return llama.Completion{}, errors.New("server error")
+			}
+			return llama.Completion{Text: strings.ToUpper(user)}, nil
+		},
+	}
+	items := []BatchItem{{"1", "a"}, {"2", "fail"}, {"3", "b"}, {"4", "c"}}
+
+	var got []BatchResult
+	err := batch.Run(context.TODO(), items, func(r BatchResult) error {
+		got = append(got, r)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Batch.Run() returns error: %v", err)
+	}
+
+	want := []BatchResult{
+		{ID: "1", Output: "FIX: A"},
+		{ID: "2", Error: "server error"},
+		{ID: "3", Output: "FIX: B"},
+		{ID: "4", Output: "FIX: C"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Batch.Run() writes %v, want %v", got, want)
+	}
+	if maxRunning.Load() > 2 {
+		t.Fatalf("Batch.Run() runs %d jobs at the same time, want at most %d", maxRunning.Load(), 2)
+	}
+}
+
+func TestReadBatchFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a/README.md", "b/README.md", "b/TODO.md"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("Fix "+name+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := ReadBatchFiles([]string{filepath.Join(dir, "a/README.md"), filepath.Join(dir, "b/TODO.md")})
+	want := []BatchItem{{ID: "README.md", Prompt: "Fix a/README.md"}, {ID: "TODO.md", Prompt: "Fix b/TODO.md"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("ReadBatchFiles() = %v, %v, want %v", got, err, want)
+	}
+
+	_, err = ReadBatchFiles([]string{filepath.Join(dir, "a/README.md"), filepath.Join(dir, "b/README.md")})
+	if err == nil || !strings.Contains(err.Error(), "same name") {
+		t.Fatalf("ReadBatchFiles() = %v, want error about the same name", err)
+	}
+}
+
+func TestCheckDirNames(t *testing.T) {
+	testcases := []struct {
+		ids  []string
+		want string
+	}{
+		{[]string{"a.md", "b/a.txt", "1"}, ""},
+		{[]string{"a/README.md", "b/README.md"}, "same file"},
+		{[]string{"a", ".."}, "not a valid file name"},
+	}
+	for _, tc := range testcases {
+		items := make([]BatchItem, len(tc.ids))
+		for i, id := range tc.ids {
+			items[i] = BatchItem{ID: id}
+		}
+		err := CheckDirNames(items)
+		if (tc.want == "" && err != nil) || (tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want))) {
+			t.Errorf("CheckDirNames(%q) = %v, want %q", tc.ids, err, tc.want)
+		}
+	}
+}
+
+func TestDirWriter(t *testing.T) {
+	dir := t.TempDir()
+	write := DirWriter(dir)
+	for _, r := range []BatchResult{{ID: "a.md", Output: "A"}, {ID: "b.md", Error: "server error"}} {
+		if err := write(r); err != nil {
+			t.Fatalf("DirWriter() returns error: %v", err)
+		}
+	}
+
+	if got, err := os.ReadFile(filepath.Join(dir, "a.md")); err != nil || string(got) != "A" {
+		t.Fatalf("DirWriter() writes %q (%v), want %q", got, err, "A")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.md")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("DirWriter() writes failed item")
+	}
+	if err := write(BatchResult{ID: "x/a.md", Output: "X"}); err == nil {
+		t.Fatalf("DirWriter() overwrites result with the same name")
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "a.md")); string(got) != "A" {
+		t.Fatalf("DirWriter() overwrites %q with %q", "A", got)
+	}
+}
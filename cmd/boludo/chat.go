@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/macie/boludo/llama"
+)
+
+// ChatSession represents an interactive conversation with the LLM.
+type ChatSession struct {
+	// Prompt specifies the initial prompt (format, system prompt) extended
+	// by each turn of the conversation.
+	Prompt llama.Prompt
+
+	// Prefix specifies an optional text added before each user turn.
+	Prefix string
+
+	// Marker specifies an optional text written to Output before reading
+	// each user turn.
+	Marker string
+
+	// Complete specifies a function which returns completion for the prompt.
+	Complete func(context.Context, llama.Prompt) (*llama.Stream, error)
+
+	// Input specifies a source of user turns (one per line).
+	Input io.Reader
+
+	// Output specifies a destination for assistant answers.
+	Output io.Writer
+}
+
+// Run reads user turns from Input until EOF and writes answers to Output.
+// Non-empty firstTurn is sent before reading Input.
+func (s *ChatSession) Run(ctx context.Context, firstTurn string) error {
+	if firstTurn != "" {
+		if err := s.turn(ctx, firstTurn); err != nil {
+			return err
+		}
+	}
+
+	scanner := bufio.NewScanner(s.Input)
+	for {
+		fmt.Fprint(s.Output, s.Marker)
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := s.turn(ctx, line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read user input: %w", err)
+	}
+
+	return nil
+}
+
+// turn sends a single user turn and stores the answer in the conversation.
+func (s *ChatSession) turn(ctx context.Context, userPrompt string) error {
+	if s.Prefix != "" {
+		userPrompt = fmt.Sprintf("%s %s", s.Prefix, userPrompt)
+	}
+	s.Prompt.Add(userPrompt)
+
+	stream, err := s.Complete(ctx, s.Prompt)
+	if err != nil {
+		s.Prompt.Messages = s.Prompt.Messages[:len(s.Prompt.Messages)-1]
+		return err
+	}
+
+	answer := strings.Builder{}
+	for stream.Next() {
+		answer.WriteString(stream.Text())
+		fmt.Fprint(s.Output, stream.Text())
+	}
+	stream.Close()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := stream.Err(); err != nil {
+		if answer.Len() > 0 {
+			fmt.Fprintln(s.Output)
+		}
+		// partial answer would mislead the model in the next turns
+		s.Prompt.Messages = s.Prompt.Messages[:len(s.Prompt.Messages)-1]
+		return fmt.Errorf("answer is incomplete: %w", err)
+	}
+	if !strings.HasSuffix(answer.String(), "\n") {
+		fmt.Fprintln(s.Output)
+	}
+
+	s.Prompt.AddAssistant(strings.TrimSpace(answer.String()))
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/macie/boludo/llama"
+)
+
+func TestChatSessionRun(t *testing.T) {
+	var prompts []string
+	answers := []string{"Hello!", "Fine.\n"}
+	session := ChatSession{
+		Prompt: llama.Prompt{Format: "chatml", System: "Be brief."},
+		Complete: func(_ context.Context, p llama.Prompt) (*llama.Stream, error) {
+			prompt, err := p.Render()
+			if err != nil {
+				return nil, err
+			}
+			prompts = append(prompts, prompt)
+			return llama.NewTextStream([]string{answers[len(prompts)-1]}, llama.Result{}), nil
+		},
+		Input:  strings.NewReader("\nHow are you?\n"),
+		Output: &strings.Builder{},
+	}
+
+	if err := session.Run(context.TODO(), "Hi"); err != nil {
+		t.Fatalf("Run(ctx, \"Hi\") returns error: %v", err)
+	}
+
+	wantOutput := "Hello!\nFine.\n"
+	if got := session.Output.(*strings.Builder).String(); got != wantOutput {
+		t.Fatalf("Run(ctx, \"Hi\") writes %q, want %q", got, wantOutput)
+	}
+	wantPrompt := "<|im_start|>system\nBe brief.<|im_end|>\n<|im_start|>user\nHi<|im_end|>\n<|im_start|>assistant\nHello!<|im_end|>\n<|im_start|>user\nHow are you?<|im_end|>\n<|im_start|>assistant\n"
+	if len(prompts) != 2 || prompts[1] != wantPrompt {
+		t.Fatalf("Run(ctx, \"Hi\") sends prompts %q, want last %q", prompts, wantPrompt)
+	}
+}
+
+func TestChatSessionRun_Incomplete(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// connection is closed before the end of the answer
+		fmt.Fprint(w, "data: {\"content\":\"Hel\",\"stop\":false}\n\n")
+	}))
+	defer server.Close()
+	client := llama.Client{Addr: server.URL, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	session := ChatSession{
+		Prompt:   llama.Prompt{Format: "chatml"},
+		Complete: client.CompleteStream,
+		Input:    strings.NewReader("How are you?\n"),
+		Output:   &strings.Builder{},
+	}
+
+	err := session.Run(context.TODO(), "Hi")
+	if err == nil || !strings.Contains(err.Error(), "answer is incomplete") {
+		t.Fatalf("Run(ctx, \"Hi\") = %v, want incomplete answer error", err)
+	}
+	if len(session.Prompt.Messages) != 0 {
+		t.Fatalf("Run(ctx, \"Hi\") stores messages %q, want none", session.Prompt.Messages)
+	}
+	if got, want := session.Output.(*strings.Builder).String(), "Hel\n"; got != want {
+		t.Fatalf("Run(ctx, \"Hi\") writes %q, want %q", got, want)
+	}
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/fs"
+	"math"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -20,21 +22,58 @@ import (
 const helpMsg = "boludo - AI personal assistant\n" +
 	"\n" +
 	"Usage:\n" +
-	"   boludo <CONFIG_ID> [--server PATH] [-t <timeout>] [PROMPT]\n" +
+	"   boludo <CONFIG_ID> [--server PATH | --addr URL] [-t <timeout>] [--chat] [--json-schema FILE] [PROMPT]\n" +
+	"   boludo <CONFIG_ID> --batch [-j N] [--output-dir DIR] [FILE...]\n" +
+	"   boludo inspect [--json] <MODEL_PATH|CONFIG_ID>\n" +
+	"   boludo serve [--server PATH] [-j N] [--idle <timeout>] <MODEL_PATH|CONFIG_ID>\n" +
+	"   boludo ps\n" +
+	"   boludo stop [MODEL_PATH|CONFIG_ID]\n" +
 	"   boludo [-h] [-v]\n" +
 	"\n" +
 	"Options:\n" +
-	"   -t <timeout>    timeout after which the program exits (default: 0).\n" +
-	"                   Valid time units: ns, us, ms, s, m, h\n" +
-	"   --server PATH   path to LLM server executable\n" +
-	"   --verbose       show more verbose debug output\n" +
+	"   -t <timeout>    timeout of the completion, after which the program exits\n" +
+	"                   with error (default: 0). Valid time units: ns, us, ms,\n" +
+	"                   s, m, h\n" +
+	"   --start-timeout <timeout>\n" +
+	"                   timeout of loading the model by LLM server (default:\n" +
+	"                   `start-timeout` from config file or 10m)\n" +
+	"   --server PATH   path to LLM server executable (default: $BOLUDO_SERVER,\n" +
+	"                   `server` from config file, llm-server or llama-server\n" +
+	"                   from $XDG_DATA_HOME/boludo, boludo directory or $PATH)\n" +
+	"   --addr URL      use running LLM server at URL (http(s)://host:port)\n" +
+	"                   instead of starting a new one. API key is read from\n" +
+	"                   `api-key` from config file or $BOLUDO_API_KEY\n" +
+	"   -i, --chat      start interactive conversation (one turn per line)\n" +
+	"   --json-schema FILE\n" +
+	"                   constrain output to JSON Schema from FILE and exit with\n" +
+	"                   error if the output does not conform to it\n" +
+	"   --batch         answer many prompts: one per FILE or one per line of\n" +
+	"                   JSONL from standard input ({\"id\": ..., \"prompt\": ...})\n" +
+	"   -j N            number of prompts processed at the same time in batch\n" +
+	"                   mode by parallel slots of LLM server (default: 1)\n" +
+	"   --output-dir DIR\n" +
+	"                   write batch answers to DIR (one file per prompt)\n" +
+	"                   instead of JSONL on standard output\n" +
+	"   --verbose       show more verbose debug output (with completion stats)\n" +
+	"   --json          print model metadata as JSON (inspect only)\n" +
+	"   --idle <timeout>\n" +
+	"                   stop the daemon after the time without completions\n" +
+	"                   (serve only, default: 10m, 0 means never)\n" +
 	"   -h              show this help message and exit\n" +
 	"   -v              show version information and exit\n" +
 	"\n" +
-	"boludo reads prompt from PROMPT, and then from standard input"
+	"boludo reads prompt from PROMPT, and then from standard input. In chat mode,\n" +
+	"PROMPT is the first turn and the next turns are read from standard input.\n" +
+	"\n" +
+	"`boludo serve` keeps the model loaded in the background daemon, which is\n" +
+	"used by other invocations with the same model. `boludo ps` lists running\n" +
+	"daemons and `boludo stop` stops them"
 
 var AppVersion = "local-dev"
 
+// defaultIdleTimeout specifies the time after which unused daemon stops.
+const defaultIdleTimeout = 10 * time.Minute
+
 // Version returns string with full version description.
 func Version() string {
 	return fmt.Sprintf("boludo %s", AppVersion)
@@ -42,13 +81,38 @@ func Version() string {
 
 // AppConfig contains configuration options for the program.
 type AppConfig struct {
-	Options     llama.Options
-	ServerPath  string
-	Prompt      llama.Prompt
-	UserPrompt  string
-	Timeout     time.Duration
-	Verbose     bool
-	ExitMessage string
+	Command      string
+	JSONOutput   bool
+	Options      llama.Options
+	ServerPath   string
+	Server       llama.ServerOptions
+	Backend      string
+	Remote       RemoteConfig
+	Prompt       llama.Prompt
+	PromptPrefix string
+	UserPrompt   string
+	Timeout      time.Duration
+	StartTimeout time.Duration
+	Chat         bool
+	Batch        BatchConfig
+	Daemon       DaemonConfig
+	Verbose      bool
+	ExitMessage  string
+}
+
+// DaemonConfig contains configuration of the background daemon.
+type DaemonConfig struct {
+	Target      string // model path or config name
+	IdleTimeout time.Duration
+	Foreground  bool
+}
+
+// BatchConfig contains configuration of the batch mode.
+type BatchConfig struct {
+	Enabled   bool
+	Jobs      int
+	OutputDir string
+	Inputs    []string
 }
 
 // NewAppConfig creates a new AppConfig from:
@@ -84,50 +148,257 @@ func NewAppConfig(cliArgs []string) (AppConfig, error) {
 		return AppConfig{}, fmt.Errorf("could not read `%s`: %w", filepath.Join(configDir, "boludo.toml"), err)
 	}
 
+	startTimeout := configFile.StartTimeout
+	if configArgs.StartTimeout != nil {
+		startTimeout = *configArgs.StartTimeout
+	}
+
 	options := llama.DefaultOptions
 	options.Update(configFile.Options(configArgs.ConfigId))
 	options.Update(configArgs.Options())
 
+	grammarPath := configFile.Commands[configArgs.ConfigId].Grammar
+	schemaPath := configFile.Commands[configArgs.ConfigId].JSONSchema
+	if configArgs.JSONSchemaPath != "" {
+		grammarPath, schemaPath = "", configArgs.JSONSchemaPath
+	}
+	if grammarPath != "" && schemaPath != "" {
+		return AppConfig{}, fmt.Errorf("invalid config '%s': grammar and json-schema cannot be used together", configArgs.ConfigId)
+	}
+	if grammarPath != "" {
+		grammar, err := os.ReadFile(grammarPath)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("could not read grammar: %w", err)
+		}
+		options.Grammar = string(grammar)
+	}
+	if schemaPath != "" {
+		schema, err := os.ReadFile(schemaPath)
+		if err != nil {
+			return AppConfig{}, fmt.Errorf("could not read JSON schema: %w", err)
+		}
+		if !json.Valid(schema) {
+			return AppConfig{}, fmt.Errorf("could not read JSON schema: '%s' is not a valid JSON", schemaPath)
+		}
+		options.JSONSchema = schema
+	}
+
+	switch configArgs.Command {
+	case "inspect", "serve", "ps", "stop":
+		if options.ModelPath == "" {
+			// not a config name, so it should be a path to the model
+			options.ModelPath = configArgs.ConfigId
+		}
+		serverPath := configArgs.ServerPath
+		if configArgs.Command == "serve" || configArgs.Command == "stop" {
+			// daemons are identified by the server path
+			serverPath, err = LocateServer(configArgs.ServerPath, configFile.ServerPath)
+			if err != nil && configArgs.Command == "serve" {
+				return AppConfig{}, err
+			}
+		}
+		idleTimeout := defaultIdleTimeout
+		if configArgs.IdleTimeout != nil {
+			idleTimeout = *configArgs.IdleTimeout
+		}
+		return AppConfig{
+			Command:      configArgs.Command,
+			JSONOutput:   configArgs.JSONOutput,
+			Options:      options,
+			ServerPath:   serverPath,
+			Server:       configFile.ServerOptions(configArgs.ConfigId),
+			StartTimeout: startTimeout,
+			Batch:        BatchConfig{Jobs: configArgs.Jobs},
+			Daemon: DaemonConfig{
+				Target:      configArgs.ConfigId,
+				IdleTimeout: idleTimeout,
+				Foreground:  configArgs.Foreground,
+			},
+			Verbose: configArgs.ShowVerbose,
+		}, nil
+	}
+
+	for name, format := range configFile.Formats {
+		if err := llama.RegisterFormat(name, format); err != nil {
+			return AppConfig{}, fmt.Errorf("could not read `%s`: %w", filepath.Join(configDir, "boludo.toml"), err)
+		}
+	}
+
+	remote := configFile.Remote(configArgs.ConfigId)
+	if configArgs.Addr != "" {
+		remote.Addr = configArgs.Addr
+	}
+	backend := strings.ToLower(configFile.Commands[configArgs.ConfigId].Backend)
+	if name, ok := strings.CutPrefix(options.ModelPath, "ollama:"); ok {
+		// model pulled into Ollama, e.g. "ollama:mistral"
+		if backend != "" && backend != "ollama" {
+			return AppConfig{}, fmt.Errorf("invalid config '%s': model '%s' cannot be used with backend '%s'", configArgs.ConfigId, options.ModelPath, backend)
+		}
+		backend = "ollama"
+		options.ModelPath = name
+	}
+	switch backend {
+	case "", "llama.cpp":
+		backend = "llama.cpp"
+	case "openai":
+		if remote.Addr == "" {
+			return AppConfig{}, fmt.Errorf("invalid config '%s': backend '%s' requires server address (addr)", configArgs.ConfigId, backend)
+		}
+	case "ollama":
+		// address is optional, see: llama.OllamaClient
+	default:
+		return AppConfig{}, fmt.Errorf("invalid config '%s': unknown backend '%s'", configArgs.ConfigId, backend)
+	}
+	var serverPath string
+	if remote.Addr != "" {
+		if err := ValidateAddr(remote.Addr); err != nil {
+			return AppConfig{}, fmt.Errorf("invalid config '%s': %w", configArgs.ConfigId, err)
+		}
+	} else if backend == "llama.cpp" {
+		serverPath, err = LocateServer(configArgs.ServerPath, configFile.ServerPath)
+		if err != nil {
+			return AppConfig{}, err
+		}
+	}
+
 	prompt := configFile.Prompt(configArgs.ConfigId)
-	promptPrefix := configFile.PromptPrefix(configArgs.ConfigId)
-	userPrompt := configArgs.Prompt
-	if promptPrefix != "" {
-		userPrompt = fmt.Sprintf("%s %s", promptPrefix, userPrompt)
+	switch backend {
+	case "llama.cpp":
+		if configArgs.Chat && prompt.Format == "" {
+			// default format marks turns only after the first answer
+			prompt.Format = llama.FormatAuto
+		}
+		if strings.EqualFold(prompt.Format, llama.FormatAuto) {
+			if prompt.Format, err = llama.DetectFormat(options.ModelPath); err != nil {
+				return AppConfig{}, fmt.Errorf("invalid config '%s': %w", configArgs.ConfigId, err)
+			}
+		}
+		if _, err := prompt.Render(); err != nil {
+			return AppConfig{}, fmt.Errorf("invalid config '%s': %w", configArgs.ConfigId, err)
+		}
+	case "ollama":
+		// format is detected by the server, unless it is set explicitly
+		if !strings.EqualFold(prompt.Format, llama.FormatAuto) {
+			if _, err := prompt.Render(); err != nil {
+				return AppConfig{}, fmt.Errorf("invalid config '%s': %w", configArgs.ConfigId, err)
+			}
+		}
+	case "openai":
+		// OpenAI-compatible servers apply chat templates on their own
 	}
 
 	return AppConfig{
-		Prompt:     prompt,
-		UserPrompt: userPrompt,
-		Options:    options,
-		ServerPath: configArgs.ServerPath,
-		Timeout:    configArgs.Timeout,
-		Verbose:    configArgs.ShowVerbose,
+		Prompt:       prompt,
+		PromptPrefix: configFile.PromptPrefix(configArgs.ConfigId),
+		UserPrompt:   configArgs.Prompt,
+		Options:      options,
+		ServerPath:   serverPath,
+		Server:       configFile.ServerOptions(configArgs.ConfigId),
+		Backend:      backend,
+		Remote:       remote,
+		Timeout:      configArgs.Timeout,
+		StartTimeout: startTimeout,
+		Chat:         configArgs.Chat,
+		Batch: BatchConfig{
+			Enabled:   configArgs.Batch,
+			Jobs:      configArgs.Jobs,
+			OutputDir: configArgs.OutputDir,
+			Inputs:    configArgs.Inputs,
+		},
+		Verbose: configArgs.ShowVerbose,
 	}, nil
 }
 
-// NewAppContext returns a cancellable context which is:
-// - cancelled when the interrupt signal is received
-// - cancelled after the timeout (if any).
-func NewAppContext(config AppConfig) (context.Context, context.CancelFunc) {
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+// LocateServer returns a path to LLM server executable. It is taken from
+// (in order):
+//   - `--server` flag
+//   - `BOLUDO_SERVER` environment variable
+//   - `server` in the config file
+//   - `$XDG_DATA_HOME/boludo` directory (`$HOME/.local/share/boludo` by default)
+//   - directory of boludo executable
+//   - `$PATH` (as llm-server or llama-server).
+func LocateServer(flagPath, configPath string) (string, error) {
+	for _, serverPath := range []string{flagPath, os.Getenv("BOLUDO_SERVER"), configPath} {
+		if serverPath != "" {
+			return serverPath, nil
+		}
+	}
+
+	var dirs []string
+	if dir := dataDir(); dir != "" {
+		dirs = append(dirs, dir)
+	}
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(exe))
+	}
+	serverPath, err := llama.FindServer(dirs...)
+	if err != nil {
+		return "", fmt.Errorf("could not locate LLM server: --server flag, BOLUDO_SERVER and `server` in config file are not set; %w", err)
+	}
+	return serverPath, nil
+}
+
+// dataDir returns a directory with user data of boludo.
+func dataDir() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return filepath.Join(dir, "boludo")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "share", "boludo")
+}
+
+// UserTurn returns the user prompt preceded by the configured prompt prefix.
+func (a AppConfig) UserTurn(userPrompt string) string {
+	if a.PromptPrefix == "" {
+		return userPrompt
+	}
+	return fmt.Sprintf("%s %s", a.PromptPrefix, userPrompt)
+}
 
+// NewAppContext returns a context which is cancelled when the interrupt
+// signal is received. The completion timeout is applied separately (see:
+// CompletionContext), so it does not limit loading of the model.
+func NewAppContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+// CompletionContext returns a context of the completion, which is cancelled
+// after the timeout (if any).
+func CompletionContext(ctx context.Context, config AppConfig) (context.Context, context.CancelFunc) {
 	if config.Timeout != 0 {
 		return context.WithTimeout(ctx, config.Timeout)
 	}
-
-	return ctx, cancel
+	return context.WithCancel(ctx)
 }
 
 // ConfigArgs contains configuration options for the program provided by the user.
 type ConfigArgs struct {
+	Command     string
 	ConfigId    string
 	Prompt      string
 	Timeout     time.Duration
 	ModelPath   string
 	ServerPath  string
+	Addr        string
+	Chat        bool
 	ShowHelp    bool
 	ShowVersion bool
 	ShowVerbose bool
+	JSONOutput  bool
+
+	JSONSchemaPath string
+
+	Batch     bool
+	Jobs      int
+	OutputDir string
+	Inputs    []string
+
+	IdleTimeout  *time.Duration
+	StartTimeout *time.Duration
+	Foreground   bool
 }
 
 // ParseArgs creates a new ConfigArgs from the given command line arguments.
@@ -138,11 +409,24 @@ func ParseArgs(cliArgs []string) (ConfigArgs, error) {
 		return ConfigArgs{}, fmt.Errorf(helpMsg)
 	}
 
-	// first argument is a config name
+	// first argument is a command or a config name
 	if !strings.HasPrefix(cliArgs[0], "-") {
 		conf.ConfigId = cliArgs[0]
 		cliArgs = cliArgs[1:]
 	}
+	switch conf.ConfigId {
+	case "ps":
+		conf.Command = conf.ConfigId
+		conf.ConfigId = ""
+	case "inspect", "serve", "stop":
+		conf.Command = conf.ConfigId
+		conf.ConfigId = ""
+		// argument of the command is a model path or a config name
+		if len(cliArgs) > 0 && !strings.HasPrefix(cliArgs[0], "-") {
+			conf.ConfigId = cliArgs[0]
+			cliArgs = cliArgs[1:]
+		}
+	}
 
 	// global options
 	f := flag.NewFlagSet("boludo", flag.ContinueOnError)
@@ -152,19 +436,55 @@ func ParseArgs(cliArgs []string) (ConfigArgs, error) {
 	f.DurationVar(&conf.Timeout, "t", 0, "")
 	f.BoolVar(&conf.ShowVerbose, "verbose", false, "")
 	f.StringVar(&conf.ServerPath, "server", "", "")
+	f.StringVar(&conf.Addr, "addr", "", "")
+	f.BoolVar(&conf.Chat, "chat", false, "")
+	f.BoolVar(&conf.Chat, "i", false, "")
+	f.BoolVar(&conf.JSONOutput, "json", false, "")
+	f.StringVar(&conf.JSONSchemaPath, "json-schema", "", "")
+	f.BoolVar(&conf.Batch, "batch", false, "")
+	f.IntVar(&conf.Jobs, "j", 0, "")
+	f.StringVar(&conf.OutputDir, "output-dir", "", "")
+	f.Func("idle", "", func(s string) error {
+		d, err := time.ParseDuration(s)
+		conf.IdleTimeout = &d
+		return err
+	})
+	f.Func("start-timeout", "", func(s string) error {
+		d, err := time.ParseDuration(s)
+		conf.StartTimeout = &d
+		return err
+	})
+	f.BoolVar(&conf.Foreground, "foreground", false, "")
 	if err := f.Parse(cliArgs); err != nil {
 		return ConfigArgs{}, fmt.Errorf("%w. See 'boludo -h' for help", err)
 	}
 
-	switch f.NArg() {
-	case 0:
+	switch {
+	case f.NArg() == 0:
 		break
-	case 1:
+	case conf.Batch && conf.Command == "":
+		conf.Inputs = f.Args()
+	case f.NArg() == 1 && conf.Command == "":
 		conf.Prompt = f.Arg(0)
+	case f.NArg() == 1 && conf.ConfigId == "" && conf.Command != "ps":
+		conf.ConfigId = f.Arg(0)
 	default:
 		return ConfigArgs{}, fmt.Errorf("too much arguments: '%s'. See 'boludo -h' for help", strings.Join(cliArgs, "', '"))
 	}
 
+	if (conf.Command == "inspect" || conf.Command == "serve") && conf.ConfigId == "" && !conf.ShowHelp && !conf.ShowVersion {
+		return ConfigArgs{}, fmt.Errorf("missing model path or config name. See 'boludo -h' for help")
+	}
+	if conf.Addr != "" && conf.ServerPath != "" {
+		return ConfigArgs{}, fmt.Errorf("--addr cannot be used with --server. See 'boludo -h' for help")
+	}
+	if conf.Batch && conf.Chat {
+		return ConfigArgs{}, fmt.Errorf("--batch cannot be used with --chat. See 'boludo -h' for help")
+	}
+	if conf.Jobs < 0 {
+		return ConfigArgs{}, fmt.Errorf("invalid number of jobs: %d. See 'boludo -h' for help", conf.Jobs)
+	}
+
 	return conf, nil
 }
 
@@ -182,16 +502,67 @@ func (a ConfigArgs) Options() llama.Options {
 }
 
 // ConfigFile represents a configuration file.
-type ConfigFile map[string]ModelSpec
+type ConfigFile struct {
+	// Commands specifies subcommands definitions.
+	Commands map[string]ModelSpec
+
+	// Formats specifies user-defined prompt formats (from the `[formats]`
+	// table).
+	Formats map[string]llama.PromptFormat
+
+	// Server specifies parameters of LLM server (from the `[server]` table)
+	// used by all subcommands.
+	Server llama.ServerOptions
+
+	// ServerPath specifies a path to LLM server executable (from the `server`
+	// key or `path` in the `[server]` table).
+	ServerPath string
+
+	// StartTimeout specifies the time limit of loading the model (from
+	// `start-timeout` in the `[server]` table).
+	// If zero, the default of llama.Server is used.
+	StartTimeout time.Duration
+}
 
 // ModelSpec represents a model specification in the configuration file.
+//
+// Zero values of sampling parameters (from TopK to Seed) mean that the
+// default value is used (see: llama.DefaultOptions).
 type ModelSpec struct {
-	Model        string
-	SystemPrompt string
-	PromptPrefix string
-	Format       string
-	Creativity   float32
-	Cutoff       float32
+	Model            string
+	SystemPrompt     string
+	PromptPrefix     string
+	Format           string
+	Creativity       float32
+	Cutoff           float32
+	TopK             int
+	TopP             float32
+	TypicalP         float32
+	RepeatPenalty    float32
+	RepeatLastN      int
+	PresencePenalty  float32
+	FrequencyPenalty float32
+	Mirostat         int
+	MirostatTau      float32
+	MirostatEta      float32
+	MaxTokens        int
+	Stop             []string
+	Seed             uint
+	Grammar          string
+	JSONSchema       string
+	Examples         []Example
+	Server           llama.ServerOptions
+	Backend          string
+	Addr             string
+	APIKey           string
+	CABundle         string
+}
+
+// Example represents a sample exchange between user and assistant used for
+// few-shot prompting.
+type Example struct {
+	User      string
+	Assistant string
 }
 
 // ParseFile reads the TOML configuration file and returns a ConfigFile.
@@ -223,7 +594,58 @@ func ParseFile(configDir fs.FS, filename string) (ConfigFile, error) {
 // Values not defined in the config file will be set to the default values
 func (c *ConfigFile) UnmarshalTOML(data interface{}) error {
 	definedConfigs, _ := data.(map[string]interface{})
+	switch server := definedConfigs["server"].(type) {
+	case nil:
+	case string:
+		c.ServerPath = os.ExpandEnv(server)
+	case map[string]interface{}:
+		options, err := parseServerOptions(c.Server, "server", server)
+		if err != nil {
+			return err
+		}
+		c.Server = options
+		if path, ok := server["path"]; ok {
+			path, err := tomlString(path, "path", "server")
+			if err != nil {
+				return err
+			}
+			c.ServerPath = os.ExpandEnv(path)
+		}
+		if timeout, ok := server["start-timeout"]; ok {
+			c.StartTimeout, err = tomlDuration(timeout, "start-timeout", "server")
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("invalid value of 'server': want path or table")
+	}
 	for configId := range definedConfigs {
+		if configId == "server" {
+			continue
+		}
+		table, ok := definedConfigs[configId].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid value of '%s': want table", configId)
+		}
+		if configId == "formats" {
+			for name, v := range table {
+				formatTable, ok := v.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("invalid value of '%s' in [formats]: want table", name)
+				}
+				format, err := parseFormat("formats."+name, formatTable)
+				if err != nil {
+					return err
+				}
+				if c.Formats == nil {
+					c.Formats = make(map[string]llama.PromptFormat)
+				}
+				c.Formats[name] = format
+			}
+			continue
+		}
+
 		defaultSpec := ModelSpec{
 			Model:        "",
 			SystemPrompt: "",
@@ -231,58 +653,350 @@ func (c *ConfigFile) UnmarshalTOML(data interface{}) error {
 			Format:       "",
 			Creativity:   llama.DefaultOptions.Temp,
 			Cutoff:       llama.DefaultOptions.MinP,
+			Server:       c.Server,
 		}
-		for k, v := range definedConfigs[configId].(map[string]interface{}) {
+		for k, v := range table {
+			var err error
 			switch k {
 			case "model":
-				defaultSpec.Model = os.ExpandEnv(v.(string))
+				defaultSpec.Model, err = tomlString(v, k, configId)
+				defaultSpec.Model = os.ExpandEnv(defaultSpec.Model)
 			case "creativity":
-				defaultSpec.Creativity = (float32)(v.(float64))
+				defaultSpec.Creativity, err = tomlFloat(v, k, configId)
 			case "cutoff":
-				defaultSpec.Cutoff = (float32)(v.(float64))
+				defaultSpec.Cutoff, err = tomlFloat(v, k, configId)
 			case "format":
-				defaultSpec.Format = v.(string)
+				defaultSpec.Format, err = tomlString(v, k, configId)
 			case "system-prompt":
-				defaultSpec.SystemPrompt = v.(string)
+				defaultSpec.SystemPrompt, err = tomlString(v, k, configId)
 			case "prompt-prefix":
-				defaultSpec.PromptPrefix = v.(string)
+				defaultSpec.PromptPrefix, err = tomlString(v, k, configId)
+			case "top-k":
+				defaultSpec.TopK, err = tomlInt(v, k, configId)
+			case "top-p":
+				defaultSpec.TopP, err = tomlFloat(v, k, configId)
+			case "typical-p":
+				defaultSpec.TypicalP, err = tomlFloat(v, k, configId)
+			case "repeat-penalty":
+				defaultSpec.RepeatPenalty, err = tomlFloat(v, k, configId)
+			case "repeat-last-n":
+				defaultSpec.RepeatLastN, err = tomlInt(v, k, configId)
+			case "presence-penalty":
+				defaultSpec.PresencePenalty, err = tomlFloat(v, k, configId)
+			case "frequency-penalty":
+				defaultSpec.FrequencyPenalty, err = tomlFloat(v, k, configId)
+			case "mirostat":
+				defaultSpec.Mirostat, err = tomlInt(v, k, configId)
+			case "mirostat-tau":
+				defaultSpec.MirostatTau, err = tomlFloat(v, k, configId)
+			case "mirostat-eta":
+				defaultSpec.MirostatEta, err = tomlFloat(v, k, configId)
+			case "max-tokens":
+				defaultSpec.MaxTokens, err = tomlInt(v, k, configId)
+			case "stop":
+				defaultSpec.Stop, err = tomlStrings(v, k, configId)
+			case "seed":
+				var seed int
+				seed, err = tomlInt(v, k, configId)
+				if seed < 0 {
+					err = invalidValue(k, configId)
+				}
+				defaultSpec.Seed = uint(seed)
+			case "grammar":
+				defaultSpec.Grammar, err = tomlString(v, k, configId)
+				defaultSpec.Grammar = os.ExpandEnv(defaultSpec.Grammar)
+			case "json-schema":
+				defaultSpec.JSONSchema, err = tomlString(v, k, configId)
+				defaultSpec.JSONSchema = os.ExpandEnv(defaultSpec.JSONSchema)
+			case "examples":
+				defaultSpec.Examples, err = parseExamples(v)
+				if err != nil {
+					err = fmt.Errorf("%w: %w", invalidValue(k, configId), err)
+				}
+			case "server":
+				serverTable, ok := v.(map[string]interface{})
+				if !ok {
+					err = invalidValue(k, configId)
+					break
+				}
+				defaultSpec.Server, err = parseServerOptions(c.Server, configId+".server", serverTable)
+			case "backend":
+				defaultSpec.Backend, err = tomlString(v, k, configId)
+			case "addr":
+				defaultSpec.Addr, err = tomlString(v, k, configId)
+			case "api-key":
+				defaultSpec.APIKey, err = tomlString(v, k, configId)
+				defaultSpec.APIKey = os.ExpandEnv(defaultSpec.APIKey)
+			case "ca-bundle":
+				defaultSpec.CABundle, err = tomlString(v, k, configId)
+				defaultSpec.CABundle = os.ExpandEnv(defaultSpec.CABundle)
+			}
+			if err != nil {
+				return err
 			}
 		}
-		(*c)[configId] = defaultSpec
+		if c.Commands == nil {
+			c.Commands = make(map[string]ModelSpec)
+		}
+		c.Commands[configId] = defaultSpec
 	}
 	return nil
 }
 
+// parseExamples returns few-shot examples from the array of tables, which is
+// decoded differently for `[[cmd.examples]]` and inline arrays.
+func parseExamples(v interface{}) ([]Example, error) {
+	var tables []map[string]interface{}
+	switch v := v.(type) {
+	case []map[string]interface{}:
+		tables = v
+	case []interface{}:
+		for _, table := range v {
+			table, ok := table.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("want array of tables with user and assistant")
+			}
+			tables = append(tables, table)
+		}
+	default:
+		return nil, errors.New("want array of tables with user and assistant")
+	}
+
+	var examples []Example
+	for _, table := range tables {
+		example := Example{}
+		for k, v := range table {
+			var ok bool
+			switch k {
+			case "user":
+				example.User, ok = v.(string)
+			case "assistant":
+				example.Assistant, ok = v.(string)
+			default:
+				ok = true
+			}
+			if !ok {
+				return nil, fmt.Errorf("'%s' is not a string", k)
+			}
+		}
+		examples = append(examples, example)
+	}
+	return examples, nil
+}
+
+// parseServerOptions returns options from the `server` table. Options not
+// defined in the table are copied from base.
+func parseServerOptions(base llama.ServerOptions, tableName string, table map[string]interface{}) (llama.ServerOptions, error) {
+	options := base
+	for k, v := range table {
+		var err error
+		switch k {
+		case "ctx-size":
+			options.CtxSize, err = tomlInt(v, k, tableName)
+		case "threads":
+			options.Threads, err = tomlInt(v, k, tableName)
+		case "batch-size":
+			options.BatchSize, err = tomlInt(v, k, tableName)
+		case "mlock":
+			options.MLock, err = tomlBool(v, k, tableName)
+		case "mmap":
+			var mmap bool
+			mmap, err = tomlBool(v, k, tableName)
+			options.NoMMap = !mmap
+		case "rope-scaling":
+			options.RopeScaling, err = tomlString(v, k, tableName)
+		case "rope-scale":
+			options.RopeScale, err = tomlFloat(v, k, tableName)
+		case "extra-args":
+			options.ExtraArgs, err = tomlStrings(v, k, tableName)
+			for i, arg := range options.ExtraArgs {
+				options.ExtraArgs[i] = os.ExpandEnv(arg)
+			}
+		}
+		if err != nil {
+			return llama.ServerOptions{}, err
+		}
+	}
+	return options, nil
+}
+
+// parseFormat returns the prompt format defined in the `[formats.name]` table.
+func parseFormat(tableName string, table map[string]interface{}) (llama.PromptFormat, error) {
+	format := llama.PromptFormat{}
+	for k, v := range table {
+		var err error
+		switch k {
+		case "template":
+			format.Template, err = tomlString(v, k, tableName)
+		case "stop":
+			format.Stop, err = tomlStrings(v, k, tableName)
+		}
+		if err != nil {
+			return llama.PromptFormat{}, err
+		}
+	}
+	return format, nil
+}
+
+// invalidValue returns an error about the value of key in the TOML table.
+func invalidValue(key, table string) error {
+	return fmt.Errorf("invalid value of '%s' in [%s]", key, table)
+}
+
+// tomlString returns the TOML value as a string.
+func tomlString(v interface{}, key, table string) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", invalidValue(key, table)
+	}
+	return s, nil
+}
+
+// tomlDuration returns the TOML value (e.g. "5m") as a duration.
+func tomlDuration(v interface{}, key, table string) (time.Duration, error) {
+	s, ok := v.(string)
+	if !ok {
+		return 0, invalidValue(key, table)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, invalidValue(key, table)
+	}
+	return d, nil
+}
+
+// tomlStrings returns the TOML value as a slice of strings.
+func tomlStrings(v interface{}, key, table string) ([]string, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, invalidValue(key, table)
+	}
+	strs := make([]string, 0, len(list))
+	for _, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return nil, invalidValue(key, table)
+		}
+		strs = append(strs, s)
+	}
+	return strs, nil
+}
+
+// tomlBool returns the TOML value as a bool.
+func tomlBool(v interface{}, key, table string) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, invalidValue(key, table)
+	}
+	return b, nil
+}
+
+// tomlFloat returns the TOML number (integer or float) as a float32.
+func tomlFloat(v interface{}, key, table string) (float32, error) {
+	switch n := v.(type) {
+	case int64:
+		return float32(n), nil
+	case float64:
+		return float32(n), nil
+	}
+	return 0, invalidValue(key, table)
+}
+
+// tomlInt returns the TOML number as an int. Floats are accepted only
+// without a fractional part.
+func tomlInt(v interface{}, key, table string) (int, error) {
+	switch n := v.(type) {
+	case int64:
+		return int(n), nil
+	case float64:
+		if n == math.Trunc(n) && math.Abs(n) <= math.MaxInt32 {
+			return int(n), nil
+		}
+	}
+	return 0, invalidValue(key, table)
+}
+
 // Options returns the llama.Options based on the ConfigFile.
 //
 // It uses default values from llama.DefaultOptions for options not specified in
 // config file.
 func (c *ConfigFile) Options(configId string) llama.Options {
-	if spec, ok := (*c)[configId]; ok {
-		return llama.Options{
-			ModelPath: spec.Model,
-			Temp:      spec.Creativity,
-			MinP:      spec.Cutoff,
+	options := llama.DefaultOptions
+	if spec, ok := c.Commands[configId]; ok {
+		options.ModelPath = spec.Model
+		options.Temp = spec.Creativity
+		options.MinP = spec.Cutoff
+		override(&options.TopK, spec.TopK)
+		override(&options.TopP, spec.TopP)
+		override(&options.TypicalP, spec.TypicalP)
+		override(&options.RepeatPenalty, spec.RepeatPenalty)
+		override(&options.RepeatLastN, spec.RepeatLastN)
+		override(&options.PresencePenalty, spec.PresencePenalty)
+		override(&options.FrequencyPenalty, spec.FrequencyPenalty)
+		override(&options.Mirostat, spec.Mirostat)
+		override(&options.MirostatTau, spec.MirostatTau)
+		override(&options.MirostatEta, spec.MirostatEta)
+		override(&options.MaxTokens, spec.MaxTokens)
+		override(&options.Seed, spec.Seed)
+		if len(spec.Stop) > 0 {
+			options.Stop = spec.Stop
 		}
 	}
 
-	return llama.DefaultOptions
+	return options
+}
+
+// override sets dst to v if v is not a zero value.
+func override[T comparable](dst *T, v T) {
+	var zero T
+	if v != zero {
+		*dst = v
+	}
 }
 
 // Prompt returns the llama.Prompt based on the ConfigFile.
+//
+// Examples defined in the config file are added as the first messages.
 func (c *ConfigFile) Prompt(configId string) llama.Prompt {
-	if spec, ok := (*c)[configId]; ok {
-		return llama.Prompt{
+	if spec, ok := c.Commands[configId]; ok {
+		prompt := llama.Prompt{
 			Format: spec.Format,
 			System: spec.SystemPrompt,
 		}
+		for _, example := range spec.Examples {
+			prompt.Add(example.User)
+			prompt.AddAssistant(example.Assistant)
+		}
+		return prompt
 	}
 	return llama.Prompt{}
 }
 
+// ServerOptions returns parameters of LLM server specified in the ConfigFile
+// for the subcommand (or common parameters for other config ids).
+func (c *ConfigFile) ServerOptions(configId string) llama.ServerOptions {
+	if spec, ok := c.Commands[configId]; ok {
+		return spec.Server
+	}
+	return c.Server
+}
+
+// Remote returns the configuration of the running LLM server specified in
+// the ConfigFile. If the API key is not specified, $BOLUDO_API_KEY is used.
+func (c *ConfigFile) Remote(configId string) RemoteConfig {
+	remote := RemoteConfig{}
+	if spec, ok := c.Commands[configId]; ok {
+		remote = RemoteConfig{Addr: spec.Addr, APIKey: spec.APIKey, CABundle: spec.CABundle}
+	}
+	if remote.APIKey == "" {
+		remote.APIKey = os.Getenv("BOLUDO_API_KEY")
+	}
+	return remote
+}
+
 // PromptPrefix returns the prompt prefix specified in the ConfigFile.
 func (c *ConfigFile) PromptPrefix(configId string) string {
-	if spec, ok := (*c)[configId]; ok {
+	if spec, ok := c.Commands[configId]; ok {
 		return spec.PromptPrefix
 	}
 	return ""
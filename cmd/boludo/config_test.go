@@ -3,16 +3,19 @@ package main
 import (
 	"errors"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/macie/boludo/llama"
 )
 
 func TestParseArgs(t *testing.T) {
+	hour := time.Hour
 	testcases := []struct {
 		args []string
 		want ConfigArgs
@@ -25,6 +28,20 @@ func TestParseArgs(t *testing.T) {
 		{[]string{"assistant", "--server", "./llm-server"}, ConfigArgs{ConfigId: "assistant", ServerPath: "./llm-server"}},
 		{[]string{"chat", "How are you?"}, ConfigArgs{ConfigId: "chat", Prompt: "How are you?"}},
 		{[]string{"chat", "-v", "How are you?"}, ConfigArgs{ConfigId: "chat", Prompt: "How are you?", ShowVersion: true}},
+		{[]string{"chat", "--chat"}, ConfigArgs{ConfigId: "chat", Chat: true}},
+		{[]string{"chat", "-i", "Hi"}, ConfigArgs{ConfigId: "chat", Prompt: "Hi", Chat: true}},
+		{[]string{"extract", "--json-schema", "schema.json"}, ConfigArgs{ConfigId: "extract", JSONSchemaPath: "schema.json"}},
+		{[]string{"proofreader", "--batch"}, ConfigArgs{ConfigId: "proofreader", Batch: true}},
+		{[]string{"proofreader", "--batch", "-j", "4", "--output-dir", "out", "a.md", "b.md"}, ConfigArgs{ConfigId: "proofreader", Batch: true, Jobs: 4, OutputDir: "out", Inputs: []string{"a.md", "b.md"}}},
+		{[]string{"inspect", "model.gguf"}, ConfigArgs{Command: "inspect", ConfigId: "model.gguf"}},
+		{[]string{"inspect", "coder", "--json"}, ConfigArgs{Command: "inspect", ConfigId: "coder", JSONOutput: true}},
+		{[]string{"inspect", "--json", "coder"}, ConfigArgs{Command: "inspect", ConfigId: "coder", JSONOutput: true}},
+		{[]string{"serve", "coder", "-j", "2", "--idle", "1h"}, ConfigArgs{Command: "serve", ConfigId: "coder", Jobs: 2, IdleTimeout: &hour}},
+		{[]string{"chat", "-t", "1h", "--start-timeout", "1h"}, ConfigArgs{ConfigId: "chat", Timeout: hour, StartTimeout: &hour}},
+		{[]string{"serve", "--foreground", "model.gguf"}, ConfigArgs{Command: "serve", ConfigId: "model.gguf", Foreground: true}},
+		{[]string{"ps"}, ConfigArgs{Command: "ps"}},
+		{[]string{"stop"}, ConfigArgs{Command: "stop"}},
+		{[]string{"stop", "coder"}, ConfigArgs{Command: "stop", ConfigId: "coder"}},
 	}
 	for _, tc := range testcases {
 		tc := tc
@@ -34,7 +51,7 @@ func TestParseArgs(t *testing.T) {
 			if err != nil {
 				t.Fatalf("ParseArgs(%v) returns error: %v", tc.args, err)
 			}
-			if got != tc.want {
+			if !reflect.DeepEqual(got, tc.want) {
 				t.Fatalf("ParseArgs(%v) = %v, want %v", tc.args, got, tc.want)
 			}
 		})
@@ -50,6 +67,14 @@ func TestParseArgs_Invalid(t *testing.T) {
 		{[]string{"edit", "--yyy"}},
 		{[]string{"assistant", "--server"}},
 		{[]string{"chat", "prompt", "prompt2"}},
+		{[]string{"inspect"}},
+		{[]string{"inspect", "model.gguf", "prompt"}},
+		{[]string{"proofreader", "--batch", "--chat"}},
+		{[]string{"proofreader", "--batch", "-j", "-1"}},
+		{[]string{"serve"}},
+		{[]string{"serve", "coder", "--idle", "never"}},
+		{[]string{"ps", "coder"}},
+		{[]string{"coder", "--addr", "localhost:8080", "--server", "llm-server"}},
 	}
 	want := ConfigArgs{}
 	for _, tc := range testcases {
@@ -60,7 +85,7 @@ func TestParseArgs_Invalid(t *testing.T) {
 			if err == nil {
 				t.Fatalf("ParseArgs(%v) does not return error", tc.args)
 			}
-			if got != want {
+			if !reflect.DeepEqual(got, want) {
 				t.Fatalf("ParseArgs(%v) = %v, want %v", tc.args, got, want)
 			}
 		})
@@ -73,7 +98,7 @@ func TestConfigArgsOptions(t *testing.T) {
 		want llama.Options
 	}{
 		{ConfigArgs{}, llama.DefaultOptions},
-		{ConfigArgs{ModelPath: "model.gguf"}, llama.Options{ModelPath: "model.gguf", Temp: 1, MinP: 0}},
+		{ConfigArgs{ModelPath: "model.gguf"}, withOptions(func(o *llama.Options) { o.ModelPath = "model.gguf" })},
 	}
 	for _, tc := range testcases {
 		tc := tc
@@ -87,21 +112,28 @@ func TestConfigArgsOptions(t *testing.T) {
 	}
 }
 
+// withOptions returns llama.DefaultOptions modified by the function.
+func withOptions(modify func(*llama.Options)) llama.Options {
+	options := llama.DefaultOptions
+	modify(&options)
+	return options
+}
+
 func TestParseFile(t *testing.T) {
 	testcases := []struct {
 		content string
 		want    ConfigFile
 	}{
 		{"", ConfigFile{}},
-		{"[chat]\nmodel = \"model.gguf\"\ncreativity = 1.2\n cutoff = 0.5\n", ConfigFile{
+		{"[chat]\nmodel = \"model.gguf\"\ncreativity = 1.2\n cutoff = 0.5\n", ConfigFile{Commands: map[string]ModelSpec{
 			"chat": ModelSpec{
 				Model:      "model.gguf",
 				Format:     "",
 				Creativity: 1.2,
 				Cutoff:     0.5,
 			},
-		}},
-		{"[edit]\nmodel = \"model.gguf\"\nprompt-prefix = 'Reword:'\n[unknown]", ConfigFile{
+		}}},
+		{"[edit]\nmodel = \"model.gguf\"\nprompt-prefix = 'Reword:'\n[unknown]", ConfigFile{Commands: map[string]ModelSpec{
 			"edit": ModelSpec{
 				Model:        "model.gguf",
 				Format:       "",
@@ -114,8 +146,8 @@ func TestParseFile(t *testing.T) {
 				Creativity: 1.0,
 				Cutoff:     0.0,
 			},
-		}},
-		{"[assistant]\nmodel = \"model.gguf\"\nprompt-prefix = 'Reword:'\nsystem-prompt = 'You are an assistant.'", ConfigFile{
+		}}},
+		{"[assistant]\nmodel = \"model.gguf\"\nprompt-prefix = 'Reword:'\nsystem-prompt = 'You are an assistant.'", ConfigFile{Commands: map[string]ModelSpec{
 			"assistant": ModelSpec{
 				Model:        "model.gguf",
 				Format:       "",
@@ -123,7 +155,106 @@ func TestParseFile(t *testing.T) {
 				SystemPrompt: "You are an assistant.",
 				Creativity:   1.0,
 			},
+		}}},
+		{"[coder]\n[[coder.examples]]\nuser = 'Add 2 and 2'\nassistant = '2 + 2'\n[[coder.examples]]\nuser = 'Add 1 and 3'\nassistant = '1 + 3'", ConfigFile{Commands: map[string]ModelSpec{
+			"coder": ModelSpec{
+				Creativity: 1.0,
+				Examples: []Example{
+					{User: "Add 2 and 2", Assistant: "2 + 2"},
+					{User: "Add 1 and 3", Assistant: "1 + 3"},
+				},
+			},
+		}}},
+		{"[chat]\nexamples = [{user = 'hi', assistant = 'yo'}]", ConfigFile{Commands: map[string]ModelSpec{
+			"chat": ModelSpec{
+				Creativity: 1.0,
+				Examples:   []Example{{User: "hi", Assistant: "yo"}},
+			},
+		}}},
+		{"[det]\ntop-k = 40\ntop-p = 0.9\ntypical-p = 0.95\nrepeat-penalty = 1.1\nrepeat-last-n = 64\npresence-penalty = 0.5\nfrequency-penalty = 0.25\nmirostat = 2\nmirostat-tau = 4.0\nmirostat-eta = 0.2\nmax-tokens = 256\nstop = ['###', 'END']\nseed = 42", ConfigFile{Commands: map[string]ModelSpec{
+			"det": ModelSpec{
+				Creativity:       1.0,
+				TopK:             40,
+				TopP:             0.9,
+				TypicalP:         0.95,
+				RepeatPenalty:    1.1,
+				RepeatLastN:      64,
+				PresencePenalty:  0.5,
+				FrequencyPenalty: 0.25,
+				Mirostat:         2,
+				MirostatTau:      4.0,
+				MirostatEta:      0.2,
+				MaxTokens:        256,
+				Stop:             []string{"###", "END"},
+				Seed:             42,
+			},
+		}}},
+		{"[numbers]\ntop-p = 1\nmirostat-tau = 5\nmax-tokens = 256.0\n[numbers.server]\nrope-scale = 2", ConfigFile{Commands: map[string]ModelSpec{
+			"numbers": ModelSpec{
+				Creativity:  1.0,
+				TopP:        1.0,
+				MirostatTau: 5.0,
+				MaxTokens:   256,
+				Server:      llama.ServerOptions{RopeScale: 2.0},
+			},
+		}}},
+		{"[extract]\ngrammar = 'list.gbnf'\njson-schema = 'schema.json'", ConfigFile{Commands: map[string]ModelSpec{
+			"extract": ModelSpec{
+				Creativity: 1.0,
+				Grammar:    "list.gbnf",
+				JSONSchema: "schema.json",
+			},
+		}}},
+		{"[formats.test]\ntemplate = '{{.System}}'\nstop = ['<|eot_id|>']\n[chat]\nformat = 'test'", ConfigFile{
+			Commands: map[string]ModelSpec{
+				"chat": ModelSpec{
+					Format:     "test",
+					Creativity: 1.0,
+				},
+			},
+			Formats: map[string]llama.PromptFormat{
+				"test": {Template: "{{.System}}", Stop: []string{"<|eot_id|>"}},
+			},
+		}},
+		{"server = '/opt/llm-server'", ConfigFile{ServerPath: "/opt/llm-server"}},
+		{"[server]\npath = '/opt/llama-server'\nthreads = 2", ConfigFile{ServerPath: "/opt/llama-server", Server: llama.ServerOptions{Threads: 2}}},
+		{"[server]\nstart-timeout = '30m'", ConfigFile{StartTimeout: 30 * time.Minute}},
+		{"[server]\nctx-size = 8192\nthreads = 4\nmlock = true\n[coder.server]\nctx-size = 32768\nmlock = false\nmmap = false\nbatch-size = 512\nrope-scaling = 'yarn'\nrope-scale = 4.0\nextra-args = ['--flash-attn']\n[chat]", ConfigFile{
+			Commands: map[string]ModelSpec{
+				"coder": ModelSpec{
+					Creativity: 1.0,
+					Server: llama.ServerOptions{
+						CtxSize:     32768,
+						Threads:     4,
+						BatchSize:   512,
+						NoMMap:      true,
+						RopeScaling: "yarn",
+						RopeScale:   4.0,
+						ExtraArgs:   []string{"--flash-attn"},
+					},
+				},
+				"chat": ModelSpec{
+					Creativity: 1.0,
+					Server:     llama.ServerOptions{CtxSize: 8192, Threads: 4, MLock: true},
+				},
+			},
+			Server: llama.ServerOptions{CtxSize: 8192, Threads: 4, MLock: true},
 		}},
+		{"[lmstudio]\nbackend = 'openai'\naddr = 'http://localhost:1234'\nmodel = 'qwen2.5-7b-instruct'", ConfigFile{Commands: map[string]ModelSpec{
+			"lmstudio": ModelSpec{
+				Model:      "qwen2.5-7b-instruct",
+				Creativity: 1.0,
+				Backend:    "openai",
+				Addr:       "http://localhost:1234",
+			},
+		}}},
+		{"[mistral]\nmodel = 'ollama:mistral'\naddr = 'gpu-box:11434'", ConfigFile{Commands: map[string]ModelSpec{
+			"mistral": ModelSpec{
+				Model:      "ollama:mistral",
+				Creativity: 1.0,
+				Addr:       "gpu-box:11434",
+			},
+		}}},
 	}
 	for _, tc := range testcases {
 		tc := tc
@@ -161,6 +292,39 @@ func TestParseFile_Invalid(t *testing.T) {
 	}
 }
 
+func TestParseFile_InvalidValue(t *testing.T) {
+	testcases := []string{
+		"[chat]\nexamples = 'hi'",
+		"[chat]\nexamples = ['hi']",
+		"[chat]\nexamples = [{user = 1, assistant = 'yo'}]",
+		"[chat]\nmax-tokens = 1.5",
+		"[chat]\nseed = -1",
+		"[chat]\ncreativity = 'high'",
+		"[chat]\nstop = ['###', 1]",
+		"[chat.server]\nmlock = 'yes'",
+		"[server]\nthreads = 'all'",
+		"[server]\nstart-timeout = 600",
+		"[formats.x]\ntemplate = 1",
+		"model = 'model.gguf'",
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc, func(t *testing.T) {
+			t.Parallel()
+			fs := fstest.MapFS{
+				"boludo.toml": {Data: []byte(tc)},
+			}
+			got, err := ParseFile(fs, "boludo.toml")
+			if err == nil || !strings.Contains(err.Error(), "invalid value of") {
+				t.Fatalf("ParseFile(fs, \"boludo.toml\") want invalid value error, got: %v", err)
+			}
+			if !reflect.DeepEqual(got, ConfigFile{}) {
+				t.Fatalf("ParseFile(fs, \"boludo.toml\") = %v, want %v", got, ConfigFile{})
+			}
+		})
+	}
+}
+
 func TestParseFile_Missing(t *testing.T) {
 	filename := "missing.toml"
 	confDir := fstest.MapFS{}
@@ -182,7 +346,18 @@ func TestConfigFileOptions(t *testing.T) {
 		file     ConfigFile
 		want     llama.Options
 	}{
-		{"chat", ConfigFile{"edit": ModelSpec{Model: "editmodel.gguf"}, "chat": ModelSpec{Model: "chatmodel.gguf", Format: "", Creativity: 0.3, Cutoff: 2}}, llama.Options{ModelPath: "chatmodel.gguf", Temp: 0.3, MinP: 2}},
+		{"chat", ConfigFile{Commands: map[string]ModelSpec{"edit": ModelSpec{Model: "editmodel.gguf"}, "chat": ModelSpec{Model: "chatmodel.gguf", Format: "", Creativity: 0.3, Cutoff: 2}}}, withOptions(func(o *llama.Options) {
+			o.ModelPath = "chatmodel.gguf"
+			o.Temp = 0.3
+			o.MinP = 2
+		})},
+		{"coder", ConfigFile{Commands: map[string]ModelSpec{"coder": ModelSpec{Creativity: 1, TopK: 40, TopP: 0.9, MaxTokens: 128, Stop: []string{"\n\n"}, Seed: 42}}}, withOptions(func(o *llama.Options) {
+			o.TopK = 40
+			o.TopP = 0.9
+			o.MaxTokens = 128
+			o.Stop = []string{"\n\n"}
+			o.Seed = 42
+		})},
 		{"invalid", ConfigFile{}, llama.DefaultOptions},
 	}
 	for _, tc := range testcases {
@@ -196,3 +371,88 @@ func TestConfigFileOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestConfigFilePrompt(t *testing.T) {
+	testcases := []struct {
+		configId string
+		file     ConfigFile
+		want     llama.Prompt
+	}{
+		{"chat", ConfigFile{Commands: map[string]ModelSpec{"chat": ModelSpec{Format: "ChatML", SystemPrompt: "Be brief."}}}, llama.Prompt{Format: "ChatML", System: "Be brief."}},
+		{"coder", ConfigFile{Commands: map[string]ModelSpec{"coder": ModelSpec{Examples: []Example{{User: "Add 2 and 2", Assistant: "2 + 2"}}}}}, llama.Prompt{Messages: []llama.Message{
+			{Role: llama.RoleUser, Content: "Add 2 and 2"},
+			{Role: llama.RoleAssistant, Content: "2 + 2"},
+		}}},
+		{"invalid", ConfigFile{}, llama.Prompt{}},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.configId, func(t *testing.T) {
+			t.Parallel()
+			got := tc.file.Prompt(tc.configId)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf(".Prompt(\"%s\") = %v, want %v", tc.configId, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestConfigFileRemote(t *testing.T) {
+	t.Setenv("BOLUDO_API_KEY", "envkey")
+	file := ConfigFile{Commands: map[string]ModelSpec{
+		"team":  ModelSpec{Addr: "https://workstation:8080", APIKey: "teamkey"},
+		"local": ModelSpec{Addr: "localhost:8080"},
+	}}
+	testcases := []struct {
+		configId string
+		want     RemoteConfig
+	}{
+		{"team", RemoteConfig{Addr: "https://workstation:8080", APIKey: "teamkey"}},
+		{"local", RemoteConfig{Addr: "localhost:8080", APIKey: "envkey"}},
+		{"invalid", RemoteConfig{APIKey: "envkey"}},
+	}
+	for _, tc := range testcases {
+		if got := file.Remote(tc.configId); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf(".Remote(\"%s\") = %v, want %v", tc.configId, got, tc.want)
+		}
+	}
+}
+
+func TestLocateServer(t *testing.T) {
+	dataHome, empty := t.TempDir(), t.TempDir()
+	dataServer := filepath.Join(dataHome, "boludo", "llm-server")
+	if err := os.MkdirAll(filepath.Dir(dataServer), 0o700); err != nil {
+		t.Fatalf("cannot create data directory: %v", err)
+	}
+	if err := os.WriteFile(dataServer, []byte("#!/bin/sh\n"), 0o700); err != nil {
+		t.Fatalf("cannot write server: %v", err)
+	}
+	t.Setenv("PATH", empty)
+	t.Setenv("XDG_DATA_HOME", dataHome)
+
+	testcases := []struct {
+		flagPath, envPath, configPath string
+		want                          string
+	}{
+		{"/flag/llm-server", "/env/llm-server", "/config/llm-server", "/flag/llm-server"},
+		{"", "/env/llm-server", "/config/llm-server", "/env/llm-server"},
+		{"", "", "/config/llm-server", "/config/llm-server"},
+		{"", "", "", dataServer},
+	}
+	for _, tc := range testcases {
+		t.Setenv("BOLUDO_SERVER", tc.envPath)
+		got, err := LocateServer(tc.flagPath, tc.configPath)
+		if err != nil {
+			t.Fatalf("LocateServer(%q, %q) returns error: %v", tc.flagPath, tc.configPath, err)
+		}
+		if got != tc.want {
+			t.Fatalf("LocateServer(%q, %q) = %q, want %q", tc.flagPath, tc.configPath, got, tc.want)
+		}
+	}
+
+	t.Setenv("XDG_DATA_HOME", empty)
+	_, err := LocateServer("", "")
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(empty, "boludo", "llm-server")) || !strings.Contains(err.Error(), "BOLUDO_SERVER") {
+		t.Fatalf("LocateServer(\"\", \"\") returns error %v, want list of tried locations", err)
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/macie/boludo/llama"
+)
+
+// Endpoints of the daemon, which are not forwarded to the LLM server.
+const (
+	daemonStatusPath   = "/boludo/status"
+	daemonShutdownPath = "/boludo/shutdown"
+)
+
+// DaemonState represents a running daemon. It is stored as JSON file in the
+// runtime directory. The daemon accepts only requests with its Token, so
+// other users cannot use it.
+type DaemonState struct {
+	Key         string              `json:"key"`
+	PID         int                 `json:"pid"`
+	Addr        string              `json:"addr"`
+	Token       string              `json:"token"`
+	ModelPath   string              `json:"model_path"`
+	ServerPath  string              `json:"server_path"`
+	Slots       int                 `json:"slots"`
+	Server      llama.ServerOptions `json:"server"`
+	IdleTimeout time.Duration       `json:"idle_timeout"`
+	Started     time.Time           `json:"started"`
+}
+
+// NewDaemonState returns the state of a daemon matching the configuration
+// (without address, PID and start time).
+func NewDaemonState(config AppConfig) DaemonState {
+	modelPath, err := filepath.Abs(config.Options.ModelPath)
+	if err != nil {
+		modelPath = config.Options.ModelPath
+	}
+	serverPath := config.ServerPath
+	if serverPath == "" {
+		// LLM server was not found
+		serverPath = "llm-server"
+	}
+	if path, err := filepath.Abs(serverPath); err == nil {
+		serverPath = path
+	}
+	slots := max(config.Batch.Jobs, 1)
+
+	return DaemonState{
+		Key:         DaemonKey(modelPath, serverPath, config.Server),
+		ModelPath:   modelPath,
+		ServerPath:  serverPath,
+		Slots:       slots,
+		Server:      config.Server,
+		IdleTimeout: config.Daemon.IdleTimeout,
+	}
+}
+
+// RuntimeDir returns a directory with state files of daemons:
+//   - `$XDG_RUNTIME_DIR/boludo` (if XDG_RUNTIME_DIR is set)
+//   - `boludo-<UID>` in the temporary directory (otherwise).
+//
+// The directory is created if it does not exist. State files direct clients
+// to daemons, so the directory is rejected if it is not private to the user.
+func RuntimeDir() (string, error) {
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("boludo-%d", os.Getuid()))
+	if xdgDir := os.Getenv("XDG_RUNTIME_DIR"); xdgDir != "" {
+		dir = filepath.Join(xdgDir, "boludo")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("could not create runtime directory: %w", err)
+	}
+	if err := checkPrivateDir(dir); err != nil {
+		return "", fmt.Errorf("could not use runtime directory: %w", err)
+	}
+	return dir, nil
+}
+
+// DaemonKey returns an identifier of the daemon serving the model with given
+// LLM server parameters. The number of slots is not a part of the key, so
+// the model is not loaded again for a different number of parallel jobs.
+func DaemonKey(modelPath, serverPath string, options llama.ServerOptions) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%+v", modelPath, serverPath, options)))
+	return hex.EncodeToString(h[:6])
+}
+
+// ReadDaemons returns states of running daemons from the directory. State
+// files of daemons which are not responding are removed.
+func ReadDaemons(dir string) ([]DaemonState, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("could not read daemons: %w", err)
+	}
+
+	var daemons []DaemonState
+	for _, path := range paths {
+		state, err := readDaemonState(path)
+		if err != nil || !state.Alive() {
+			os.Remove(path)
+			continue
+		}
+		daemons = append(daemons, state)
+	}
+	sort.Slice(daemons, func(i, j int) bool {
+		return daemons[i].Started.Before(daemons[j].Started)
+	})
+	return daemons, nil
+}
+
+// FindDaemon returns a state of the running daemon with given key.
+func FindDaemon(dir, key string) (DaemonState, bool) {
+	path := filepath.Join(dir, key+".json")
+	state, err := readDaemonState(path)
+	if err != nil {
+		return DaemonState{}, false
+	}
+	if !state.Alive() {
+		os.Remove(path)
+		return DaemonState{}, false
+	}
+	return state, true
+}
+
+// readDaemonState reads the state file.
+func readDaemonState(path string) (DaemonState, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return DaemonState{}, err
+	}
+	var state DaemonState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return DaemonState{}, err
+	}
+	return state, nil
+}
+
+// WriteDaemons writes the list of daemons in human-readable form.
+func WriteDaemons(w io.Writer, daemons []DaemonState) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tPID\tADDRESS\tSLOTS\tIDLE TIMEOUT\tSTARTED\tMODEL")
+	for _, d := range daemons {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%s\t%s\t%s\n", d.Key, d.PID, d.Addr, d.Slots, d.IdleTimeout, d.Started.Format(time.DateTime), d.ModelPath)
+	}
+	return tw.Flush()
+}
+
+// Alive checks if the daemon responds to requests.
+func (d DaemonState) Alive() bool {
+	resp, err := d.request(http.MethodGet, daemonStatusPath, time.Second)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	var status DaemonState
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return false
+	}
+	return status.Key == d.Key && status.PID == d.PID
+}
+
+// Stop asks the daemon to stop.
+func (d DaemonState) Stop() error {
+	resp, err := d.request(http.MethodPost, daemonShutdownPath, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("could not stop daemon %s: %w", d.Key, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not stop daemon %s: %s", d.Key, resp.Status)
+	}
+	return nil
+}
+
+// request sends a request with the token to the daemon.
+func (d DaemonState) request(method, path string, timeout time.Duration) (*http.Response, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%s%s", d.Addr, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+d.Token)
+	client := http.Client{Timeout: timeout}
+	return client.Do(req)
+}
+
+// Daemon represents a long-lived LLM server shared between invocations.
+//
+// Clients connect to the daemon, which forwards requests to the LLM server
+// and stops it after IdleTimeout without requests.
+type Daemon struct {
+	// Server specifies the LLM server. Its address is chosen by the daemon.
+	Server llama.Server
+
+	// State specifies the model and parameters of the daemon. Address, PID,
+	// token and start time are set by Run.
+	State DaemonState
+
+	// Dir specifies an existing directory for the state file (see: RuntimeDir).
+	Dir string
+
+	// Logger specifies logger for the daemon.
+	// If nil, the default logger is used.
+	Logger *slog.Logger
+}
+
+// Run starts the LLM server and serves requests until the context is
+// cancelled, the daemon is stopped or idle for too long.
+func (d *Daemon) Run(ctx context.Context) error {
+	if d.Logger == nil {
+		d.Logger = slog.Default()
+	}
+	d.Server.Addr = "localhost:0"
+	if err := d.Server.Start(ctx, d.State.ModelPath); err != nil {
+		return fmt.Errorf("could not start daemon: %w", err)
+	}
+	defer d.Server.Close()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return fmt.Errorf("could not start daemon: %w", err)
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		ln.Close()
+		return fmt.Errorf("could not start daemon: %w", err)
+	}
+	d.State.Addr = ln.Addr().String()
+	d.State.PID = os.Getpid()
+	d.State.Token = hex.EncodeToString(token)
+	d.State.Started = time.Now()
+
+	proxy := newActivityProxy(&url.URL{Scheme: "http", Host: d.Server.Addr})
+	shutdown := make(chan struct{})
+	shutdownOnce := sync.Once{}
+	srv := http.Server{Handler: d.handler(proxy, func() {
+		shutdownOnce.Do(func() { close(shutdown) })
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	statePath := filepath.Join(d.Dir, d.State.Key+".json")
+	if err := writeDaemonState(statePath, d.State); err != nil {
+		return fmt.Errorf("could not start daemon: %w", err)
+	}
+	defer os.Remove(statePath)
+	d.Logger.Info("daemon started", slog.String("addr", d.State.Addr), slog.String("model", d.State.ModelPath))
+
+	ticker := time.NewTicker(max(d.State.IdleTimeout/10, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			d.Logger.Info("daemon interrupted")
+			return nil
+		case <-shutdown:
+			d.Logger.Info("daemon stopped on request")
+			return nil
+		case <-d.Server.Done():
+			return fmt.Errorf("LLM server crashed: %w", d.Server.Err())
+		case <-ticker.C:
+			if d.State.IdleTimeout > 0 && proxy.idle() >= d.State.IdleTimeout {
+				d.Logger.Info("daemon stopped after idle timeout", slog.Duration("idle", d.State.IdleTimeout))
+				return nil
+			}
+		}
+	}
+}
+
+// handler returns a handler of daemon requests. Requests without the token
+// of the daemon are rejected, other requests are forwarded to the proxy.
+func (d *Daemon) handler(proxy http.Handler, shutdown func()) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", proxy)
+	mux.HandleFunc(daemonStatusPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d.State)
+	})
+	mux.HandleFunc(daemonShutdownPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		shutdown()
+	})
+
+	want := []byte("Bearer " + d.State.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d.State.Token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// the token is not meant for the LLM server
+		r.Header.Del("Authorization")
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// writeDaemonState atomically writes the state file.
+func writeDaemonState(path string, state DaemonState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// activityProxy forwards requests to the LLM server and tracks the time
+// since the last request.
+type activityProxy struct {
+	proxy *httputil.ReverseProxy
+
+	mu         sync.Mutex
+	active     int
+	lastActive time.Time
+}
+
+func newActivityProxy(target *url.URL) *activityProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	// completions are streamed
+	proxy.FlushInterval = -1
+	return &activityProxy{proxy: proxy, lastActive: time.Now()}
+}
+
+func (p *activityProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.active++
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.active--
+		p.lastActive = time.Now()
+		p.mu.Unlock()
+	}()
+
+	p.proxy.ServeHTTP(w, r)
+}
+
+// idle returns the time since the end of the last request. It is 0 while
+// any request is processed.
+func (p *activityProxy) idle() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active > 0 {
+		return 0
+	}
+	return time.Since(p.lastActive)
+}
+
+// StartDaemon runs `boludo serve` with given arguments in the background and
+// waits until the daemon with given key is ready. Output of the daemon is
+// written to the log file in the existing dir.
+func StartDaemon(ctx context.Context, args []string, dir, key string) (DaemonState, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return DaemonState{}, fmt.Errorf("could not start daemon: %w", err)
+	}
+	logPath := filepath.Join(dir, key+".log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return DaemonState{}, fmt.Errorf("could not start daemon: %w", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return DaemonState{}, fmt.Errorf("could not start daemon: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if state, ok := FindDaemon(dir, key); ok {
+			return state, nil
+		}
+		select {
+		case err := <-exited:
+			if err == nil {
+				err = errors.New("exited")
+			}
+			return DaemonState{}, fmt.Errorf("could not start daemon: %w. See log: %s", err, logPath)
+		case <-ctx.Done():
+			cmd.Process.Kill()
+			return DaemonState{}, fmt.Errorf("could not start daemon: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
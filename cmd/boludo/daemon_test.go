@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/macie/boludo/llama"
+)
+
+func TestDaemonKey(t *testing.T) {
+	key := DaemonKey("/models/a.gguf", "/bin/llm-server", llama.ServerOptions{})
+	for _, other := range []string{
+		DaemonKey("/models/b.gguf", "/bin/llm-server", llama.ServerOptions{}),
+		DaemonKey("/models/a.gguf", "/usr/bin/llm-server", llama.ServerOptions{}),
+		DaemonKey("/models/a.gguf", "/bin/llm-server", llama.ServerOptions{CtxSize: 8192}),
+	} {
+		if other == key {
+			t.Errorf("DaemonKey() = %q for different parameters", key)
+		}
+	}
+
+	batch := NewDaemonState(AppConfig{Options: llama.Options{ModelPath: "/models/a.gguf"}, ServerPath: "/bin/llm-server", Batch: BatchConfig{Jobs: 4}})
+	if batch.Key != key || batch.Slots != 4 {
+		t.Errorf("NewDaemonState() = %+v, want key %q and 4 slots", batch, key)
+	}
+}
+
+func TestRuntimeDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are not checked on Windows")
+	}
+	xdgDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", xdgDir)
+	want := filepath.Join(xdgDir, "boludo")
+	if got, err := RuntimeDir(); err != nil || got != want {
+		t.Fatalf("RuntimeDir() = %q, %v, want %q", got, err, want)
+	}
+
+	if err := os.Chmod(want, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RuntimeDir(); err == nil || !strings.Contains(err.Error(), "mode") {
+		t.Fatalf("RuntimeDir() = %v, want error about mode", err)
+	}
+
+	other := t.TempDir()
+	if err := os.Chmod(other, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(want); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(other, want); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RuntimeDir(); err == nil || !strings.Contains(err.Error(), "symbolic link") {
+		t.Fatalf("RuntimeDir() = %v, want error about symbolic link", err)
+	}
+}
+
+func TestReadDaemons(t *testing.T) {
+	dir := t.TempDir()
+	alive := DaemonState{Key: "alive", PID: 42, ModelPath: "/models/a.gguf"}
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != daemonStatusPath {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(alive)
+	}))
+	defer daemon.Close()
+	alive.Addr = strings.TrimPrefix(daemon.URL, "http://")
+
+	stopped := httptest.NewServer(http.NotFoundHandler())
+	stale := DaemonState{Key: "stale", PID: 43, Addr: strings.TrimPrefix(stopped.URL, "http://")}
+	stopped.Close()
+
+	for _, state := range []DaemonState{alive, stale} {
+		if err := writeDaemonState(filepath.Join(dir, state.Key+".json"), state); err != nil {
+			t.Fatalf("cannot write daemon state: %v", err)
+		}
+	}
+
+	got, err := ReadDaemons(dir)
+	if err != nil {
+		t.Fatalf("ReadDaemons() returns error: %v", err)
+	}
+	if !reflect.DeepEqual(got, []DaemonState{alive}) {
+		t.Fatalf("ReadDaemons() = %v, want %v", got, []DaemonState{alive})
+	}
+	if _, err := os.Stat(filepath.Join(dir, "stale.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("ReadDaemons() does not remove state of stopped daemon")
+	}
+	if _, ok := FindDaemon(dir, "alive"); !ok {
+		t.Fatalf("FindDaemon(dir, \"alive\") does not find running daemon")
+	}
+}
+
+func TestDaemonHandler(t *testing.T) {
+	var forwarded atomic.Bool
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Store(r.Header.Get("Authorization") == "")
+	})
+	var stopped atomic.Bool
+	d := Daemon{State: DaemonState{Key: "k", PID: 42, Token: "secret"}}
+	front := httptest.NewServer(d.handler(backend, func() { stopped.Store(true) }))
+	defer front.Close()
+	d.State.Addr = strings.TrimPrefix(front.URL, "http://")
+
+	for _, path := range []string{daemonStatusPath, daemonShutdownPath, "/completion"} {
+		resp, err := http.Post(front.URL+path, "application/json", nil)
+		if err != nil {
+			t.Fatalf("cannot send request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("POST %s without token returns %s, want %d", path, resp.Status, http.StatusUnauthorized)
+		}
+	}
+	if stopped.Load() || forwarded.Load() {
+		t.Fatalf("Daemon handles requests without token")
+	}
+
+	other := d.State
+	other.Token = "invalid"
+	if other.Alive() {
+		t.Fatalf("DaemonState.Alive() = true with invalid token")
+	}
+	if !d.State.Alive() {
+		t.Fatalf("DaemonState.Alive() = false with valid token")
+	}
+	resp, err := d.State.request(http.MethodPost, "/completion", time.Second)
+	if err != nil {
+		t.Fatalf("cannot send request: %v", err)
+	}
+	resp.Body.Close()
+	if !forwarded.Load() {
+		t.Fatalf("Daemon does not forward request with token (without the token)")
+	}
+	if err := d.State.Stop(); err != nil || !stopped.Load() {
+		t.Fatalf("DaemonState.Stop() = %v, want daemon stopped", err)
+	}
+}
+
+func TestActivityProxy(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer backend.Close()
+	target, _ := url.Parse(backend.URL)
+	proxy := newActivityProxy(target)
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+
+	time.Sleep(10 * time.Millisecond)
+	if proxy.idle() == 0 {
+		t.Fatalf("activityProxy.idle() = 0 before requests")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := http.Get(front.URL + "/completion")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	for i := 0; proxy.idle() != 0; i++ {
+		if i > 100 {
+			t.Fatalf("activityProxy.idle() != 0 during request")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	<-done
+	for i := 0; proxy.idle() == 0; i++ {
+		if i > 100 {
+			t.Fatalf("activityProxy.idle() = 0 after request")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if idle := proxy.idle(); idle > time.Second {
+		t.Fatalf("activityProxy.idle() = %v after request, want time since the request", idle)
+	}
+}
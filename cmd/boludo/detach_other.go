@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "syscall"
+
+// detachedProcAttr returns attributes of a process which outlives its parent.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return nil
+}
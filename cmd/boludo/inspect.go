@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/macie/boludo/llama"
+)
+
+// ModelReport represents a summary of the model file.
+type ModelReport struct {
+	Path           string            `json:"path"`
+	Name           string            `json:"name,omitempty"`
+	Architecture   string            `json:"architecture"`
+	Parameters     uint64            `json:"parameters"`
+	Quantization   string            `json:"quantization,omitempty"`
+	ContextLength  uint64            `json:"context_length"`
+	VocabularySize int               `json:"vocabulary_size"`
+	PromptFormat   string            `json:"prompt_format,omitempty"`
+	SpecialTokens  map[string]string `json:"special_tokens"`
+	ChatTemplate   string            `json:"chat_template,omitempty"`
+	Tensors        []TensorReport    `json:"tensors"`
+}
+
+// TensorReport represents a summary of a single tensor.
+type TensorReport struct {
+	Name  string   `json:"name"`
+	Shape []uint64 `json:"shape"`
+	Type  string   `json:"type"`
+}
+
+// NewModelReport creates a new ModelReport from the GGUF header.
+func NewModelReport(path string, gguf llama.GGUF) ModelReport {
+	report := ModelReport{
+		Path:           path,
+		Name:           gguf.Name(),
+		Architecture:   gguf.Architecture(),
+		Parameters:     gguf.ParameterCount(),
+		Quantization:   gguf.FileType(),
+		ContextLength:  gguf.ContextLength(),
+		VocabularySize: gguf.VocabularySize(),
+		SpecialTokens:  gguf.SpecialTokens(),
+		ChatTemplate:   gguf.ChatTemplate(),
+		Tensors:        make([]TensorReport, len(gguf.Tensors)),
+	}
+	report.PromptFormat, _ = gguf.PromptFormat()
+	for i, tensor := range gguf.Tensors {
+		report.Tensors[i] = TensorReport{
+			Name:  tensor.Name,
+			Shape: tensor.Dimensions,
+			Type:  tensor.TypeName(),
+		}
+	}
+	return report
+}
+
+// WriteJSON writes the report as a JSON object.
+func (r ModelReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteText writes the report in human-readable form.
+func (r ModelReport) WriteText(w io.Writer) error {
+	tokens := make([]string, 0, len(r.SpecialTokens))
+	for name, token := range r.SpecialTokens {
+		tokens = append(tokens, fmt.Sprintf("%s=%s", name, token))
+	}
+	sort.Strings(tokens)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "path:\t%s\n", r.Path)
+	fmt.Fprintf(tw, "name:\t%s\n", r.Name)
+	fmt.Fprintf(tw, "architecture:\t%s\n", r.Architecture)
+	fmt.Fprintf(tw, "parameters:\t%s (%d)\n", humanCount(r.Parameters), r.Parameters)
+	fmt.Fprintf(tw, "quantization:\t%s\n", r.Quantization)
+	fmt.Fprintf(tw, "context length:\t%d\n", r.ContextLength)
+	fmt.Fprintf(tw, "vocabulary size:\t%d\n", r.VocabularySize)
+	fmt.Fprintf(tw, "prompt format:\t%s\n", r.PromptFormat)
+	fmt.Fprintf(tw, "special tokens:\t%s\n", strings.Join(tokens, " "))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "chat template:")
+	for _, line := range strings.Split(r.ChatTemplate, "\n") {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+
+	fmt.Fprintln(w, "tensors:")
+	tw = tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, tensor := range r.Tensors {
+		fmt.Fprintf(tw, "  %s\t%v\t%s\n", tensor.Name, tensor.Shape, tensor.Type)
+	}
+	return tw.Flush()
+}
+
+// humanCount returns a number with metric suffix (e.g. 7.2B).
+func humanCount(n uint64) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.1fB", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1fK", float64(n)/1e3)
+	default:
+		return fmt.Sprint(n)
+	}
+}
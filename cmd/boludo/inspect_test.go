@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/macie/boludo/llama"
+)
+
+func testingGGUF() llama.GGUF {
+	return llama.GGUF{
+		Version: 3,
+		Metadata: map[string]any{
+			"general.architecture":        "llama",
+			"general.name":                "tiny",
+			"general.file_type":           uint32(15),
+			"llama.context_length":        uint32(4096),
+			"tokenizer.ggml.tokens":       []any{"<unk>", "<s>", "</s>"},
+			"tokenizer.ggml.bos_token_id": uint32(1),
+			"tokenizer.ggml.eos_token_id": uint32(2),
+			"tokenizer.chat_template":     "{{ '<|im_start|>' }}",
+		},
+		Tensors: []llama.TensorInfo{
+			{Name: "token_embd.weight", Dimensions: []uint64{2048, 1000}, Type: 12},
+			{Name: "output_norm.weight", Dimensions: []uint64{2048}, Type: 0},
+		},
+	}
+}
+
+func TestModelReportWriteText(t *testing.T) {
+	report := NewModelReport("model.gguf", testingGGUF())
+	output := new(bytes.Buffer)
+	if err := report.WriteText(output); err != nil {
+		t.Fatalf("WriteText() returns error: %v", err)
+	}
+
+	got := output.String()
+	for _, want := range []string{
+		"path:             model.gguf\n",
+		"architecture:     llama\n",
+		"parameters:       2.1M (2050048)\n",
+		"quantization:     Q4_K_M\n",
+		"context length:   4096\n",
+		"vocabulary size:  3\n",
+		"prompt format:    chatml\n",
+		"special tokens:   bos=<s> eos=</s>\n",
+		"chat template:\n  {{ '<|im_start|>' }}\n",
+		"  token_embd.weight   [2048 1000]  Q4_K\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("WriteText() = %q, want to contain %q", got, want)
+		}
+	}
+}
+
+func TestModelReportWriteJSON(t *testing.T) {
+	report := NewModelReport("model.gguf", testingGGUF())
+	output := new(bytes.Buffer)
+	if err := report.WriteJSON(output); err != nil {
+		t.Fatalf("WriteJSON() returns error: %v", err)
+	}
+
+	var got ModelReport
+	if err := json.Unmarshal(output.Bytes(), &got); err != nil {
+		t.Fatalf("WriteJSON() returns invalid JSON: %v", err)
+	}
+	if !reflect.DeepEqual(got, report) {
+		t.Fatalf("WriteJSON() = %v, want %v", got, report)
+	}
+}
@@ -3,10 +3,12 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/macie/boludo"
 	"github.com/macie/boludo/llama"
@@ -31,27 +33,152 @@ func main() {
 		slog.SetDefault(slog.New(defaultLogHandler))
 	}
 
-	ctx, cancel := NewAppContext(config)
+	ctx, cancel := NewAppContext()
 	defer cancel()
 
+	if config.Command != "" {
+		switch config.Command {
+		case "inspect":
+			err = inspect(config)
+		case "serve":
+			err = serve(ctx, config, defaultLogHandler.Level)
+		case "ps":
+			err = ps()
+		case "stop":
+			err = stop(config)
+		}
+		if err != nil {
+			slog.Error(fmt.Sprint(err))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	server := llama.Server{
-		Path:   config.ServerPath,
-		Logger: slog.New(boludo.UnstructuredHandler{Prefix: "[llm-server]", Level: defaultLogHandler.Level}),
+		Path: config.ServerPath,
+		// concurrent commands use separate servers; the selected address is
+		// passed to the client by llama.Serve
+		Addr:         "localhost:0",
+		Options:      &config.Server,
+		Logger:       slog.New(boludo.UnstructuredHandler{Prefix: "[llm-server]", Level: defaultLogHandler.Level}),
+		StartTimeout: config.StartTimeout,
+		// batch jobs are processed by separate slots
+		Slots:        config.Batch.Jobs,
+		ContBatching: config.Batch.Jobs > 1,
 	}
 	client := llama.Client{
 		Options: &config.Options,
 		Logger:  slog.New(boludo.UnstructuredHandler{Prefix: "[llm-client]", Level: defaultLogHandler.Level}),
+		Slots:   config.Batch.Jobs,
+	}
+	remote := config.Remote.Addr != ""
+	daemonRunning := false
+	var completer llama.Completer
+	switch {
+	case config.Backend == "openai":
+		httpClient, err := NewHTTPClient(config.Remote.CABundle)
+		if err != nil {
+			slog.Error(fmt.Sprint(err))
+			os.Exit(1)
+		}
+		completer = &llama.OpenAIClient{
+			Addr: config.Remote.Addr,
+			// the server identifies models by names
+			Model:      config.Options.ModelPath,
+			APIKey:     config.Remote.APIKey,
+			Options:    &config.Options,
+			HTTPClient: httpClient,
+			Logger:     client.Logger,
+		}
+		slog.Info("using OpenAI-compatible server", slog.String("addr", config.Remote.Addr))
+	case config.Backend == "ollama":
+		httpClient, err := NewHTTPClient(config.Remote.CABundle)
+		if err != nil {
+			slog.Error(fmt.Sprint(err))
+			os.Exit(1)
+		}
+		completer = &llama.OllamaClient{
+			Addr:       config.Remote.Addr,
+			Model:      config.Options.ModelPath,
+			APIKey:     config.Remote.APIKey,
+			Options:    &config.Options,
+			HTTPClient: httpClient,
+			Logger:     client.Logger,
+		}
+		slog.Info("using Ollama server", slog.String("model", config.Options.ModelPath))
+	case remote:
+		if err := ConnectRemote(ctx, &client, config.Remote, config.Options.ModelPath); err != nil {
+			slog.Error(fmt.Sprint(err))
+			os.Exit(1)
+		}
+		slog.Info("using running LLM server", slog.String("addr", config.Remote.Addr))
+	default:
+		dir, err := RuntimeDir()
+		if err != nil {
+			slog.Warn(fmt.Sprintf("running daemons are ignored: %v", err))
+			break
+		}
+		var daemon DaemonState
+		daemon, daemonRunning = FindDaemon(dir, NewDaemonState(config).Key)
+		if daemonRunning {
+			slog.Info("using running daemon", slog.String("key", daemon.Key), slog.String("addr", daemon.Addr))
+			client.Addr = daemon.Addr
+			client.APIKey = daemon.Token
+			if daemon.Slots < max(config.Batch.Jobs, 1) {
+				// jobs wait for free slots of the daemon
+				slog.Warn("daemon has fewer slots than jobs", slog.Int("slots", daemon.Slots), slog.Int("jobs", config.Batch.Jobs))
+				client.Slots = daemon.Slots
+			}
+		}
 	}
 	llama.SetDefault(server, client)
+	if completer != nil {
+		llama.SetCompleter(completer)
+	}
 
-	if err := llama.Serve(ctx, config.Options.ModelPath); err != nil {
-		slog.Error(fmt.Sprint(err))
-		os.Exit(1)
+	if completer == nil && !remote && !daemonRunning {
+		if err := llama.Serve(ctx, config.Options.ModelPath); err != nil {
+			slog.Error(fmt.Sprint(err))
+			os.Exit(1)
+		}
+	}
+	// -t limits the completion, not loading of the model
+	ctx, cancelCompletion := CompletionContext(ctx, config)
+	defer cancelCompletion()
+	// os.Exit does not run deferred functions
+	exit := func(code int) {
+		llama.Close()
+		os.Exit(code)
+	}
+
+	if config.Chat {
+		session := ChatSession{
+			Prompt:   config.Prompt,
+			Prefix:   config.PromptPrefix,
+			Complete: llama.CompleteStream,
+			Input:    os.Stdin,
+			Output:   os.Stdout,
+		}
+		if input, err := os.Stdin.Stat(); err == nil && (input.Mode()&os.ModeCharDevice) != 0 {
+			session.Marker = "> "
+		}
+		if err := session.Run(ctx, config.UserPrompt); err != nil && ctx.Err() == nil {
+			slog.Error(fmt.Sprint(serverErr(err)))
+			exit(1)
+		}
+		exit(reportContextErr(ctx))
+	}
+
+	if config.Batch.Enabled {
+		if err := batch(ctx, config); err != nil && ctx.Err() == nil {
+			slog.Error(fmt.Sprint(err))
+			exit(1)
+		}
+		exit(reportContextErr(ctx))
 	}
-	defer llama.Close()
 
 	userPrompt := strings.Builder{}
-	userPrompt.WriteString(config.UserPrompt)
+	userPrompt.WriteString(config.UserTurn(config.UserPrompt))
 
 	input, err := os.Stdin.Stat()
 	if err == nil && (input.Mode()&os.ModeCharDevice) == 0 {
@@ -65,26 +192,243 @@ func main() {
 
 	config.Prompt.Add(strings.Trim(userPrompt.String(), "\n"))
 
-	output, err := llama.Complete(ctx, config.Prompt)
+	stream, err := llama.CompleteStream(ctx, config.Prompt)
 	if err != nil {
 		slog.Error(fmt.Sprint(err))
-		os.Exit(1)
+		exit(1)
+	}
+
+	answer := strings.Builder{}
+	for stream.Next() {
+		answer.WriteString(stream.Text())
+		fmt.Fprint(os.Stdout, stream.Text())
+	}
+	stream.Close()
+	if err := stream.Err(); err != nil && ctx.Err() == nil {
+		slog.Error(fmt.Sprintf("answer is incomplete: %v", serverErr(err)))
+		exit(1)
 	}
+	reportResult(stream.Result())
 
-	for token := range output {
-		fmt.Fprint(os.Stdout, token)
+	if config.Options.JSONSchema != nil && ctx.Err() == nil {
+		if err := ValidateJSON(config.Options.JSONSchema, []byte(answer.String())); err != nil {
+			slog.Error(fmt.Sprint(err))
+			exit(1)
+		}
 	}
 
+	exit(reportContextErr(ctx))
+}
+
+// reportContextErr logs the reason of context cancellation (if any) and
+// returns the exit code. After cancellation the answer may be incomplete, so
+// the code is non-zero.
+func reportContextErr(ctx context.Context) int {
 	switch ctx.Err() {
 	case nil:
-		// no error
+		return 0
 	case context.Canceled:
-		slog.Info("completion cancelled by user")
+		slog.Warn("completion cancelled by user")
 	case context.DeadlineExceeded:
-		slog.Info("completion needs more time than expected")
+		slog.Warn("completion needs more time than expected")
 	default:
-		slog.Info("completion was interrupted")
+		slog.Warn("completion was interrupted")
+	}
+	return 1
+}
+
+// serverErr returns the reason of LLM server crash, if the completion error
+// was caused by it.
+func serverErr(err error) error {
+	select {
+	case <-llama.Done():
+		if crash := llama.Err(); crash != nil {
+			return fmt.Errorf("LLM server crashed: %w", crash)
+		}
+	case <-time.After(100 * time.Millisecond):
+		// server is still running
+	}
+	return err
+}
+
+// reportResult logs metadata of the finished completion.
+func reportResult(result llama.Result) {
+	slog.Info("completion finished",
+		slog.Int("prompt_tokens", result.TokensEvaluated),
+		slog.Int("predicted_tokens", result.TokensPredicted),
+		slog.String("tokens_per_second", fmt.Sprintf("%.2f", result.TokensPerSecond)),
+		slog.String("stop_reason", string(result.StopReason)))
+	if result.Truncated {
+		slog.Warn("prompt was truncated to fit the context size")
+	}
+	if result.StopReason == llama.StopLimit {
+		slog.Warn("answer was truncated at the limit of tokens")
+	}
+}
+
+// batch answers many prompts from files or JSONL on standard input.
+func batch(ctx context.Context, config AppConfig) error {
+	var items []BatchItem
+	var err error
+	if len(config.Batch.Inputs) > 0 {
+		items, err = ReadBatchFiles(config.Batch.Inputs)
+	} else {
+		items, err = ReadBatchJSONL(os.Stdin)
+	}
+	if err != nil {
+		return err
 	}
 
-	os.Exit(0)
+	write := JSONLWriter(os.Stdout)
+	if config.Batch.OutputDir != "" {
+		if err := CheckDirNames(items); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(config.Batch.OutputDir, 0o755); err != nil {
+			return fmt.Errorf("could not create output directory: %w", err)
+		}
+		write = DirWriter(config.Batch.OutputDir)
+	}
+
+	b := Batch{
+		Prompt:   config.Prompt,
+		Prefix:   config.PromptPrefix,
+		Jobs:     config.Batch.Jobs,
+		Complete: llama.CompleteText,
+	}
+	if config.Options.JSONSchema != nil {
+		b.Validate = func(answer string) error {
+			return ValidateJSON(config.Options.JSONSchema, []byte(answer))
+		}
+	}
+
+	failed := 0
+	err = b.Run(ctx, items, func(result BatchResult) error {
+		if result.Error != "" {
+			failed++
+			slog.Error(fmt.Sprintf("batch item '%s' failed: %s", result.ID, result.Error))
+		}
+		return write(result)
+	})
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d batch items failed", failed, len(items))
+	}
+	return nil
+}
+
+// serve starts the daemon in the background (or in the foreground, if
+// requested).
+func serve(ctx context.Context, config AppConfig, logLevel slog.Level) error {
+	dir, err := RuntimeDir()
+	if err != nil {
+		return err
+	}
+	state := NewDaemonState(config)
+	if running, ok := FindDaemon(dir, state.Key); ok {
+		fmt.Printf("daemon %s is already running at %s\n", running.Key, running.Addr)
+		return nil
+	}
+
+	if !config.Daemon.Foreground {
+		args := []string{
+			"serve", "--foreground",
+			"--server", state.ServerPath,
+			"-j", fmt.Sprint(state.Slots),
+			"--idle", state.IdleTimeout.String(),
+		}
+		if config.StartTimeout != 0 {
+			args = append(args, "--start-timeout", config.StartTimeout.String())
+		}
+		if config.Verbose {
+			args = append(args, "--verbose")
+		}
+		// server options are read by the daemon from the config
+		args = append(args, config.Daemon.Target)
+
+		started, err := StartDaemon(ctx, args, dir, state.Key)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("daemon %s started at %s\n", started.Key, started.Addr)
+		return nil
+	}
+
+	daemon := Daemon{
+		Server: llama.Server{
+			Path:         state.ServerPath,
+			Options:      &state.Server,
+			StartTimeout: config.StartTimeout,
+			Logger:       slog.New(boludo.UnstructuredHandler{Prefix: "[llm-server]", Level: logLevel}),
+			Slots:        state.Slots,
+			ContBatching: state.Slots > 1,
+		},
+		State: state,
+		Dir:   dir,
+	}
+	return daemon.Run(ctx)
+}
+
+// ps prints running daemons.
+func ps() error {
+	dir, err := RuntimeDir()
+	if err != nil {
+		return err
+	}
+	daemons, err := ReadDaemons(dir)
+	if err != nil {
+		return err
+	}
+	return WriteDaemons(os.Stdout, daemons)
+}
+
+// stop stops running daemons of the model (or all daemons, if the model is
+// not specified).
+func stop(config AppConfig) error {
+	dir, err := RuntimeDir()
+	if err != nil {
+		return err
+	}
+	daemons, err := ReadDaemons(dir)
+	if err != nil {
+		return err
+	}
+
+	model := ""
+	if config.Options.ModelPath != "" {
+		model = NewDaemonState(config).ModelPath
+	}
+	var errs []error
+	stopped := 0
+	for _, daemon := range daemons {
+		if model != "" && daemon.ModelPath != model {
+			continue
+		}
+		if err := daemon.Stop(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		stopped++
+		slog.Info("daemon stopped", slog.String("key", daemon.Key))
+	}
+	if model != "" && stopped == 0 && len(errs) == 0 {
+		return fmt.Errorf("no daemon is running for model '%s'", model)
+	}
+	return errors.Join(errs...)
+}
+
+// inspect prints metadata of the model file.
+func inspect(config AppConfig) error {
+	gguf, err := llama.ReadModelFile(config.Options.ModelPath)
+	if err != nil {
+		return err
+	}
+
+	report := NewModelReport(config.Options.ModelPath, gguf)
+	if config.JSONOutput {
+		return report.WriteJSON(os.Stdout)
+	}
+	return report.WriteText(os.Stdout)
 }
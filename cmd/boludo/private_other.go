@@ -0,0 +1,24 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// checkPrivateDir checks if the directory is not a symbolic link. Ownership
+// and permissions are not checked, because they are not available.
+func checkPrivateDir(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("'%s' is a symbolic link", path)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", path)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/macie/boludo/llama"
+)
+
+// RemoteConfig contains configuration of the running LLM server, which is
+// used instead of starting a new one.
+type RemoteConfig struct {
+	Addr     string
+	APIKey   string
+	CABundle string
+}
+
+// ValidateAddr checks if the address of LLM server is in the form "host:port"
+// or "http(s)://host:port".
+func ValidateAddr(addr string) error {
+	if !strings.Contains(addr, "://") {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid server address '%s': %w", addr, err)
+		}
+		return nil
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return fmt.Errorf("invalid server address '%s': %w", addr, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid server address '%s': want http(s)://host:port", addr)
+	}
+	return nil
+}
+
+// NewHTTPClient returns HTTP client which trusts certificates from the CA
+// bundle (in PEM format) in addition to the system ones. If caBundle is
+// empty, http.DefaultClient is returned.
+func NewHTTPClient(caBundle string) (*http.Client, error) {
+	if caBundle == "" {
+		return http.DefaultClient, nil
+	}
+	pem, err := os.ReadFile(caBundle)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("could not read CA bundle: '%s' contains no PEM certificates", caBundle)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
+
+// ConnectRemote configures the client to use the running LLM server and
+// checks that the server serves the model (compared by file name, because
+// the server may store models in other directory). Empty modelPath matches
+// any model.
+func ConnectRemote(ctx context.Context, client *llama.Client, remote RemoteConfig, modelPath string) error {
+	httpClient, err := NewHTTPClient(remote.CABundle)
+	if err != nil {
+		return err
+	}
+	client.Addr = remote.Addr
+	client.APIKey = remote.APIKey
+	client.HTTPClient = httpClient
+
+	props, err := client.Props(ctx)
+	if err != nil {
+		return fmt.Errorf("could not connect to LLM server at %s: %w", remote.Addr, err)
+	}
+	if modelPath != "" && filepath.Base(props.ModelPath) != filepath.Base(modelPath) {
+		return fmt.Errorf("LLM server at %s serves model '%s', want '%s'", remote.Addr, props.ModelPath, filepath.Base(modelPath))
+	}
+	return nil
+}
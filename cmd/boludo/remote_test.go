@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/macie/boludo/llama"
+)
+
+func TestValidateAddr(t *testing.T) {
+	testcases := []struct {
+		addr  string
+		valid bool
+	}{
+		{"localhost:8080", true},
+		{"http://workstation:8080", true},
+		{"https://llm.example.com", true},
+		{"workstation", false},
+		{"ftp://workstation:21", false},
+		{"https://", false},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.addr, func(t *testing.T) {
+			t.Parallel()
+			if err := ValidateAddr(tc.addr); (err == nil) != tc.valid {
+				t.Fatalf("ValidateAddr(%q) = %v, want valid: %v", tc.addr, err, tc.valid)
+			}
+		})
+	}
+}
+
+func TestConnectRemote(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/props" || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"model_path": "/srv/models/a.gguf", "total_slots": 1}`)
+	}))
+	t.Cleanup(server.Close)
+	caBundle := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caBundle, cert, 0o600); err != nil {
+		t.Fatalf("cannot write CA bundle: %v", err)
+	}
+
+	testcases := []struct {
+		name      string
+		remote    RemoteConfig
+		modelPath string
+		wantErr   string
+	}{
+		{"same model", RemoteConfig{Addr: server.URL, APIKey: "secret", CABundle: caBundle}, "/home/user/models/a.gguf", ""},
+		{"any model", RemoteConfig{Addr: server.URL, APIKey: "secret", CABundle: caBundle}, "", ""},
+		{"other model", RemoteConfig{Addr: server.URL, APIKey: "secret", CABundle: caBundle}, "b.gguf", "serves model"},
+		{"invalid key", RemoteConfig{Addr: server.URL, APIKey: "invalid", CABundle: caBundle}, "", "401"},
+		{"unknown CA", RemoteConfig{Addr: server.URL, APIKey: "secret"}, "", "certificate"},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			client := llama.Client{}
+			err := ConnectRemote(context.TODO(), &client, tc.remote, tc.modelPath)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Fatalf("ConnectRemote() returns error: %v", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Fatalf("ConnectRemote() returns error %v, want %q", err, tc.wantErr)
+			}
+			if client.Addr != tc.remote.Addr {
+				t.Fatalf("ConnectRemote() sets client.Addr = %q, want %q", client.Addr, tc.remote.Addr)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidateJSON checks if data conforms to the JSON Schema.
+//
+// It supports the subset of JSON Schema used to constrain the output of
+// LLM server (see: https://github.com/ggerganov/llama.cpp/blob/master/grammars/README.md#json-schemas--gbnf).
+// Unsupported keywords are ignored.
+func ValidateJSON(schema, data []byte) error {
+	var root, value any
+	if err := json.Unmarshal(schema, &root); err != nil {
+		return fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("output is not a valid JSON: %w", err)
+	}
+
+	v := schemaValidator{root: root}
+	if err := v.validate(root, value, "$"); err != nil {
+		if errors.Is(err, errInvalidSchema) {
+			return err
+		}
+		return fmt.Errorf("output does not conform to JSON schema: %w", err)
+	}
+	return nil
+}
+
+// errInvalidSchema is returned for schemas which cannot be used for
+// validation. Unlike other errors, it is not ignored by combinators (anyOf,
+// oneOf, not).
+var errInvalidSchema = errors.New("invalid JSON schema")
+
+// schemaValidator validates JSON values against a JSON Schema.
+type schemaValidator struct {
+	root any
+
+	// refs contains references resolved for the current value. Resolving
+	// any of them again would never end.
+	refs []string
+}
+
+func (v schemaValidator) validate(schema any, value any, path string) error {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		if allowed, ok := schema.(bool); ok && !allowed {
+			return fmt.Errorf("%s: value is not allowed", path)
+		}
+		return nil
+	}
+
+	if ref, ok := s["$ref"].(string); ok {
+		if slices.Contains(v.refs, ref) {
+			return fmt.Errorf("%w: %s: cyclic reference %q", errInvalidSchema, path, ref)
+		}
+		target, err := v.resolve(ref)
+		if err != nil {
+			return err
+		}
+		refValidator := schemaValidator{root: v.root, refs: append(slices.Clone(v.refs), ref)}
+		if err := refValidator.validate(target, value, path); err != nil {
+			return err
+		}
+	}
+
+	if t, ok := s["type"]; ok && !matchesType(t, value) {
+		return fmt.Errorf("%s: expected type %v", path, t)
+	}
+	if enum, ok := s["enum"].([]any); ok {
+		found := false
+		for _, e := range enum {
+			found = found || reflect.DeepEqual(e, value)
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of %v", path, enum)
+		}
+	}
+	if c, ok := s["const"]; ok && !reflect.DeepEqual(c, value) {
+		return fmt.Errorf("%s: value is not equal to %v", path, c)
+	}
+
+	// references are resolved again for nested values
+	items := schemaValidator{root: v.root}
+	switch value := value.(type) {
+	case map[string]any:
+		if err := items.validateObject(s, value, path); err != nil {
+			return err
+		}
+	case []any:
+		if err := items.validateArray(s, value, path); err != nil {
+			return err
+		}
+	case string:
+		n := float64(utf8.RuneCountInString(value))
+		if limit, ok := s["minLength"].(float64); ok && n < limit {
+			return fmt.Errorf("%s: string shorter than %v", path, limit)
+		}
+		if limit, ok := s["maxLength"].(float64); ok && n > limit {
+			return fmt.Errorf("%s: string longer than %v", path, limit)
+		}
+		if pattern, ok := s["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("%w: %s: %w", errInvalidSchema, path, err)
+			}
+			if !re.MatchString(value) {
+				return fmt.Errorf("%s: string does not match pattern %s", path, pattern)
+			}
+		}
+	case float64:
+		if limit, ok := s["minimum"].(float64); ok && value < limit {
+			return fmt.Errorf("%s: number less than %v", path, limit)
+		}
+		if limit, ok := s["maximum"].(float64); ok && value > limit {
+			return fmt.Errorf("%s: number greater than %v", path, limit)
+		}
+		if limit, ok := s["exclusiveMinimum"].(float64); ok && value <= limit {
+			return fmt.Errorf("%s: number not greater than %v", path, limit)
+		}
+		if limit, ok := s["exclusiveMaximum"].(float64); ok && value >= limit {
+			return fmt.Errorf("%s: number not less than %v", path, limit)
+		}
+	}
+
+	if all, ok := s["allOf"].([]any); ok {
+		for _, sub := range all {
+			if err := v.validate(sub, value, path); err != nil {
+				return err
+			}
+		}
+	}
+	if anyOf, ok := s["anyOf"].([]any); ok {
+		n, err := v.countValid(anyOf, value, path)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("%s: value does not match any schema from anyOf", path)
+		}
+	}
+	if oneOf, ok := s["oneOf"].([]any); ok {
+		n, err := v.countValid(oneOf, value, path)
+		if err != nil {
+			return err
+		}
+		if n != 1 {
+			return fmt.Errorf("%s: value does not match exactly one schema from oneOf", path)
+		}
+	}
+	if not, ok := s["not"]; ok {
+		n, err := v.countValid([]any{not}, value, path)
+		if err != nil {
+			return err
+		}
+		if n != 0 {
+			return fmt.Errorf("%s: value matches schema from not", path)
+		}
+	}
+
+	return nil
+}
+
+func (v schemaValidator) validateObject(s map[string]any, value map[string]any, path string) error {
+	if required, ok := s["required"].([]any); ok {
+		for _, name := range required {
+			if _, ok := value[fmt.Sprint(name)]; !ok {
+				return fmt.Errorf("%s: missing property %q", path, name)
+			}
+		}
+	}
+	properties, _ := s["properties"].(map[string]any)
+	for name, item := range value {
+		itemPath := fmt.Sprintf("%s.%s", path, name)
+		if sub, ok := properties[name]; ok {
+			if err := v.validate(sub, item, itemPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if additional, ok := s["additionalProperties"]; ok {
+			if err := v.validate(additional, item, itemPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v schemaValidator) validateArray(s map[string]any, value []any, path string) error {
+	n := float64(len(value))
+	if limit, ok := s["minItems"].(float64); ok && n < limit {
+		return fmt.Errorf("%s: array shorter than %v", path, limit)
+	}
+	if limit, ok := s["maxItems"].(float64); ok && n > limit {
+		return fmt.Errorf("%s: array longer than %v", path, limit)
+	}
+	prefix, _ := s["prefixItems"].([]any)
+	for i, item := range value {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+		sub, ok := s["items"]
+		if i < len(prefix) {
+			sub, ok = prefix[i], true
+		}
+		if !ok {
+			continue
+		}
+		if err := v.validate(sub, item, itemPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countValid returns the number of schemas matching the value. Invalid
+// schema is reported as error.
+func (v schemaValidator) countValid(schemas []any, value any, path string) (int, error) {
+	n := 0
+	for _, sub := range schemas {
+		err := v.validate(sub, value, path)
+		switch {
+		case err == nil:
+			n++
+		case errors.Is(err, errInvalidSchema):
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// resolve returns a subschema referenced by local JSON pointer (e.g.
+// "#/$defs/item").
+func (v schemaValidator) resolve(ref string) (any, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("%w: unsupported reference %q", errInvalidSchema, ref)
+	}
+	node := v.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		obj, ok := node.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: unresolved reference %q", errInvalidSchema, ref)
+		}
+		if node, ok = obj[token]; !ok {
+			return nil, fmt.Errorf("%w: unresolved reference %q", errInvalidSchema, ref)
+		}
+	}
+	return node, nil
+}
+
+// matchesType checks if value has JSON type specified by the "type" keyword.
+func matchesType(t any, value any) bool {
+	if types, ok := t.([]any); ok {
+		for _, t := range types {
+			if matchesType(t, value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch t {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	default:
+		return true
+	}
+}
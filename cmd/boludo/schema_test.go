@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testingSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string", "minLength": 1, "pattern": "^[A-Z]"},
+		"age": {"type": "integer", "minimum": 0},
+		"tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}, "maxItems": 2},
+		"role": {"enum": ["admin", "user"]}
+	},
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"$defs": {"tag": {"type": "string"}}
+}`
+
+func TestValidateJSON(t *testing.T) {
+	testcases := []struct {
+		data string
+	}{
+		{`{"name": "Ann", "age": 42}`},
+		{` {"name": "Bob", "age": 0, "tags": ["a", "b"], "role": "user"}` + "\n"},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.data, func(t *testing.T) {
+			t.Parallel()
+			if err := ValidateJSON([]byte(testingSchema), []byte(tc.data)); err != nil {
+				t.Fatalf("ValidateJSON(schema, %s) returns error: %v", tc.data, err)
+			}
+		})
+	}
+}
+
+func TestValidateJSON_Invalid(t *testing.T) {
+	testcases := []struct {
+		data string
+	}{
+		{`{"name": "Ann", "age": 42`},
+		{`["Ann", 42]`},
+		{`{"name": "Ann"}`},
+		{`{"name": "ann", "age": 42}`},
+		{`{"name": "", "age": 42}`},
+		{`{"name": "Ann", "age": 4.2}`},
+		{`{"name": "Ann", "age": -1}`},
+		{`{"name": "Ann", "age": 42, "tags": ["a", 1]}`},
+		{`{"name": "Ann", "age": 42, "tags": ["a", "b", "c"]}`},
+		{`{"name": "Ann", "age": 42, "role": "root"}`},
+		{`{"name": "Ann", "age": 42, "email": "ann@example.com"}`},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.data, func(t *testing.T) {
+			t.Parallel()
+			if err := ValidateJSON([]byte(testingSchema), []byte(tc.data)); err == nil {
+				t.Fatalf("ValidateJSON(schema, %s) does not return error", tc.data)
+			}
+		})
+	}
+}
+
+func TestValidateJSON_Combinators(t *testing.T) {
+	schema := `{"anyOf": [{"type": "string"}, {"type": "null"}], "not": {"const": "forbidden"}}`
+	for data, valid := range map[string]bool{`"ok"`: true, `null`: true, `1`: false, `"forbidden"`: false} {
+		err := ValidateJSON([]byte(schema), []byte(data))
+		if (err == nil) != valid {
+			t.Errorf("ValidateJSON(%s, %s) = %v, want valid: %v", schema, data, err, valid)
+		}
+	}
+}
+
+func TestValidateJSON_Recursive(t *testing.T) {
+	testcases := []struct {
+		schema string
+		data   string
+		want   string
+	}{
+		{`{"$defs": {"node": {"type": "object", "properties": {"next": {"$ref": "#/$defs/node"}}}}, "$ref": "#/$defs/node"}`, `{"next": {"next": {}}}`, ""},
+		{`{"$defs": {"node": {"type": "object", "properties": {"next": {"$ref": "#/$defs/node"}}}}, "$ref": "#/$defs/node"}`, `{"next": {"next": 1}}`, "expected type"},
+		{`{"$defs": {"a": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`, `1`, "cyclic reference"},
+		{`{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"allOf": [{"$ref": "#/$defs/a"}]}}, "$ref": "#/$defs/a"}`, `1`, "cyclic reference"},
+		{`{"$ref": "#"}`, `1`, "cyclic reference"},
+		{`{"not": {"$ref": "#"}}`, `1`, "cyclic reference"},
+		{`{"$defs": {"a": {"anyOf": [{"$ref": "#/$defs/a"}, {"type": "number"}]}}, "$ref": "#/$defs/a"}`, `1`, "cyclic reference"},
+	}
+	for _, tc := range testcases {
+		err := ValidateJSON([]byte(tc.schema), []byte(tc.data))
+		if (tc.want == "" && err != nil) || (tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want))) {
+			t.Errorf("ValidateJSON(%s, %s) = %v, want %q", tc.schema, tc.data, err, tc.want)
+		}
+	}
+}
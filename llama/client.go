@@ -1,7 +1,6 @@
 package llama
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -9,6 +8,9 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/macie/boludo"
 )
@@ -17,121 +19,531 @@ import (
 //
 // See: https://github.com/ggerganov/llama.cpp/blob/master/examples/server/README.md#api-endpoints
 type completionRequest struct {
-	Prompt          string  `json:"prompt"`
-	Temp            float32 `json:"temperature"`
-	TopK            int     `json:"top_k"`
-	MinP            float32 `json:"min_p"`
-	TopP            float32 `json:"top_p"`
-	Seed            int     `json:"seed"`
-	WithoutNewlines bool    `json:"penalize_nl"`
-	RepeatPenalty   float32 `json:"repeat_penalty"`
-	RepeatLastN     int     `json:"repeat_last_n"`
-	PredictNum      int     `json:"n_predict"`
-	Streaming       bool    `json:"stream"`
+	Prompt           string          `json:"prompt"`
+	Temp             float32         `json:"temperature"`
+	TopK             int             `json:"top_k"`
+	MinP             float32         `json:"min_p"`
+	TopP             float32         `json:"top_p"`
+	TypicalP         float32         `json:"typical_p"`
+	Seed             int             `json:"seed"`
+	WithoutNewlines  bool            `json:"penalize_nl"`
+	RepeatPenalty    float32         `json:"repeat_penalty"`
+	RepeatLastN      int             `json:"repeat_last_n"`
+	PresencePenalty  float32         `json:"presence_penalty"`
+	FrequencyPenalty float32         `json:"frequency_penalty"`
+	Mirostat         int             `json:"mirostat"`
+	MirostatTau      float32         `json:"mirostat_tau"`
+	MirostatEta      float32         `json:"mirostat_eta"`
+	PredictNum       int             `json:"n_predict"`
+	Stop             []string        `json:"stop,omitempty"`
+	Grammar          string          `json:"grammar,omitempty"`
+	JSONSchema       json.RawMessage `json:"json_schema,omitempty"`
+	Streaming        bool            `json:"stream"`
 }
 
 // completionResponse represents completion response from LLM server.
 //
 // See: https://github.com/ggerganov/llama.cpp/blob/master/examples/server/README.md#api-endpoints
 type completionResponse struct {
-	Content string `json:"content"`
-	Stop    bool   `json:"stop"`
+	Content         string         `json:"content"`
+	Stop            bool           `json:"stop"`
+	TokensEvaluated int            `json:"tokens_evaluated"`
+	TokensPredicted int            `json:"tokens_predicted"`
+	Truncated       bool           `json:"truncated"`
+	StoppedEOS      bool           `json:"stopped_eos"`
+	StoppedWord     bool           `json:"stopped_word"`
+	StoppedLimit    bool           `json:"stopped_limit"`
+	StoppingWord    string         `json:"stopping_word"`
+	Settings        map[string]any `json:"generation_settings"`
+	Timings         struct {
+		PredictedPerSecond float64 `json:"predicted_per_second"`
+	} `json:"timings"`
+}
+
+// result returns metadata of the finished completion.
+func (r completionResponse) result() Result {
+	result := Result{
+		TokensEvaluated: r.TokensEvaluated,
+		TokensPredicted: r.TokensPredicted,
+		TokensPerSecond: r.Timings.PredictedPerSecond,
+		StoppingWord:    r.StoppingWord,
+		Truncated:       r.Truncated,
+		Settings:        r.Settings,
+	}
+	switch {
+	case r.StoppedEOS:
+		result.StopReason = StopEOS
+	case r.StoppedWord:
+		result.StopReason = StopWord
+	case r.StoppedLimit:
+		result.StopReason = StopLimit
+	}
+	return result
 }
 
 // Client represents client for LLM server.
 type Client struct {
-	// Addr specifies the address of the LLM server.
+	// Addr specifies the address of the LLM server in the form "host:port"
+	// or as URL "http(s)://host:port".
 	// If empty, "localhost:24114" is used.
 	Addr string
 
+	// APIKey specifies an optional key of the LLM server (see: `--api-key`
+	// flag of the server). It is sent as a bearer token.
+	APIKey string
+
+	// HTTPClient specifies the HTTP client used for requests (e.g. with custom
+	// TLS configuration).
+	// If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
 	// Options specifies options for underlying LLM server.
 	// If nil, DefaultOptions are used.
 	Options *Options
 
 	// Logger specifies logger for the client.
 	Logger *slog.Logger
+
+	// Slots specifies the maximum number of concurrent completions sent to
+	// the LLM server (see: Server.Slots). Next completions wait for a free
+	// slot. Clients with the same Addr share slots, and their number is set
+	// by the first client which sends a completion.
+	// If less than 1, completions are not limited.
+	Slots int
+}
+
+var (
+	slotsMu sync.Mutex
+	// slots limits concurrent completions by server address.
+	slots = make(map[string]chan struct{})
+)
+
+// acquireSlot waits for a free slot of the LLM server and returns a function
+// which releases it.
+func (c *Client) acquireSlot(ctx context.Context) (func(), error) {
+	if c.Slots < 1 {
+		return func() {}, nil
+	}
+
+	addr := c.addr()
+	slotsMu.Lock()
+	sem, ok := slots[addr]
+	if !ok {
+		// semaphore is never replaced, because its slots may be held
+		sem = make(chan struct{}, c.Slots)
+		slots[addr] = sem
+	}
+	slotsMu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		once := sync.Once{}
+		return func() { once.Do(func() { <-sem }) }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
-// Complete returns a channel with completion results for given string.
+// options returns Options of the client.
+func (c *Client) options() *Options {
+	return optionsOrDefault(c.Options)
+}
+
+// optionsOrDefault returns options or DefaultOptions, if options are nil.
+func optionsOrDefault(options *Options) *Options {
+	if options == nil {
+		return &DefaultOptions
+	}
+	return options
+}
+
+// logger returns Logger of the client.
+func (c *Client) logger() *slog.Logger {
+	return loggerOrDefault(c.Logger)
+}
+
+// loggerOrDefault returns logger or the default logger of clients, if logger
+// is nil.
+func loggerOrDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.New(boludo.UnstructuredHandler{Prefix: "[llm-client]", Level: slog.LevelInfo})
+	}
+	return logger
+}
+
+// addr returns address of the LLM server.
+func (c *Client) addr() string {
+	if c.Addr == "" {
+		return "localhost:24114"
+	}
+	return c.Addr
+}
+
+// url returns URL of the endpoint of the LLM server.
+func (c *Client) url(endpoint string) string {
+	addr := c.addr()
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return strings.TrimSuffix(addr, "/") + endpoint
+}
+
+// newRequest returns a request to the endpoint of the LLM server.
+func (c *Client) newRequest(ctx context.Context, method, endpoint string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url(endpoint), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	return req, nil
+}
+
+// httpClient returns HTTPClient of the client.
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
+
+// ServerProps represents properties of the running LLM server.
+type ServerProps struct {
+	// ModelPath specifies the path of the model on the server machine.
+	ModelPath string
+
+	// TotalSlots specifies the number of completions processed in parallel.
+	TotalSlots int
+}
+
+// send sends a request with JSON body (if not nil) to the endpoint of the
+// LLM server and returns the successful response. The caller must close the
+// response body.
+func (c *Client) send(ctx context.Context, method, endpoint string, body any) (*http.Response, error) {
+	var content []byte
+	var reqBody io.Reader
+	if body != nil {
+		var err error
+		if content, err = json.Marshal(body); err != nil {
+			return nil, fmt.Errorf("request cannot be serialized: %w", err)
+		}
+		reqBody = bytes.NewReader(content)
+	}
+	req, err := c.newRequest(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("request cannot be created: %w", err)
+	}
+	c.logger().Info("request", slog.String("url", req.URL.String()), slog.String("body", string(content)))
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request cannot be sent: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, statusErr(resp)
+	}
+	return resp, nil
+}
+
+// statusErr returns the error of unsuccessful response with the message
+// reported by the LLM server, if any.
+func statusErr(resp *http.Response) error {
+	var body struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body) == nil && len(body.Error) > 0 {
+		// OpenAI-compatible servers report objects, Ollama reports strings
+		var msg string
+		if json.Unmarshal(body.Error, &msg) != nil {
+			var e serverError
+			json.Unmarshal(body.Error, &e)
+			msg = e.Message
+		}
+		if msg != "" {
+			return fmt.Errorf("LLM server returned error: %s: %s", resp.Status, msg)
+		}
+	}
+	return fmt.Errorf("LLM server returned error: %s", resp.Status)
+}
+
+// call sends a request with JSON body (if not nil) to the endpoint of the
+// LLM server and decodes JSON response to v.
+func (c *Client) call(ctx context.Context, method, endpoint string, body any, v any) error {
+	resp, err := c.send(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("cannot decode server response: %w", err)
+	}
+	return nil
+}
+
+// Props returns properties of the LLM server reported by its props endpoint.
+func (c *Client) Props(ctx context.Context) (ServerProps, error) {
+	var props struct {
+		ModelPath       string `json:"model_path"`
+		TotalSlots      int    `json:"total_slots"`
+		DefaultSettings struct {
+			Model string `json:"model"`
+		} `json:"default_generation_settings"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/props", nil, &props); err != nil {
+		return ServerProps{}, fmt.Errorf("cannot read properties of LLM server: %w", err)
+	}
+	if props.ModelPath == "" {
+		// older servers report the model in generation settings
+		props.ModelPath = props.DefaultSettings.Model
+	}
+	return ServerProps{ModelPath: props.ModelPath, TotalSlots: props.TotalSlots}, nil
+}
+
+// Tokenize returns tokens of the text in the vocabulary of the model.
+func (c *Client) Tokenize(ctx context.Context, text string) ([]int, error) {
+	var resp struct {
+		Tokens []int `json:"tokens"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/tokenize", map[string]string{"content": text}, &resp); err != nil {
+		return nil, fmt.Errorf("could not tokenize: %w", err)
+	}
+	return resp.Tokens, nil
+}
+
+// Embed returns the embedding vector of the text. The server must be started
+// with `--embedding` flag (see: ServerOptions.ExtraArgs).
+func (c *Client) Embed(ctx context.Context, text string) ([]float32, error) {
+	var resp json.RawMessage
+	if err := c.call(ctx, http.MethodPost, "/embedding", map[string]string{"content": text}, &resp); err != nil {
+		return nil, fmt.Errorf("could not embed: %w", err)
+	}
+
+	var single struct {
+		Embedding []float32 `json:"embedding"`
+	}
+	if err := json.Unmarshal(resp, &single); err == nil {
+		return single.Embedding, nil
+	}
+	// newer servers return a list of results with pooled embedding (or
+	// embeddings of all tokens, if pooling is disabled)
+	var multi []struct {
+		Embedding [][]float32 `json:"embedding"`
+	}
+	if err := json.Unmarshal(resp, &multi); err != nil || len(multi) == 0 || len(multi[0].Embedding) == 0 {
+		return nil, fmt.Errorf("could not embed: unexpected server response: %s", resp)
+	}
+	return multi[0].Embedding[0], nil
+}
+
+// Complete returns a channel with completion results for given string. The
+// channel is closed at the end of the answer or on error. Use CompleteStream
+// to distinguish between them.
+//
+// Client methods are safe for concurrent use.
+//
+// Prompt in FormatAuto is rendered in the format detected from Options.ModelPath.
 func (c *Client) Complete(ctx context.Context, p Prompt) (chan string, error) {
-	if c.Options == nil {
-		c.Options = &DefaultOptions
-	}
-	req := completionRequest{
-		Prompt:          p.String(),
-		Temp:            c.Options.Temp, // 1.0 means disable
-		TopK:            0,              // 0 means disable
-		MinP:            c.Options.MinP, // 0.0 means disable
-		TopP:            1.0,            // 1.0 means disable
-		Seed:            5489,           // -1 means random seed
-		PredictNum:      -1,             // -1 means infinite
-		RepeatPenalty:   1.0,            // 1.0 means disable
-		RepeatLastN:     0,              // 0 means disable
-		Streaming:       true,
-		WithoutNewlines: false,
-	}
-	ch, err := c.infer(ctx, req)
+	return complete(ctx, c, p)
+}
+
+// CompleteStream returns a Stream with completion results for given string.
+// The caller must close the stream.
+//
+// Prompt in FormatAuto is rendered in the format detected from Options.ModelPath.
+func (c *Client) CompleteStream(ctx context.Context, p Prompt) (*Stream, error) {
+	req, err := c.request(p)
+	if err != nil {
+		return nil, fmt.Errorf("could not complete: %w", err)
+	}
+	req.Streaming = true
+	stream, err := c.infer(ctx, req)
 	if err != nil {
 		return nil, fmt.Errorf("could not complete: %w", err)
 	}
-	return ch, nil
+	return stream, nil
 }
 
-// infer is a low-level function for sending completion requests to the LLM server.
-func (c *Client) infer(ctx context.Context, req completionRequest) (chan string, error) {
-	if c.Logger == nil {
-		c.Logger = slog.New(boludo.UnstructuredHandler{Prefix: "[llm-client]", Level: slog.LevelInfo})
+// CompleteText returns the whole answer for given string. It blocks until
+// the answer is generated or the context is cancelled.
+//
+// Prompt in FormatAuto is rendered in the format detected from Options.ModelPath.
+func (c *Client) CompleteText(ctx context.Context, p Prompt) (Completion, error) {
+	req, err := c.request(p)
+	if err != nil {
+		return Completion{}, fmt.Errorf("could not complete: %w", err)
 	}
-	if c.Addr == "" {
-		c.Addr = "localhost:24114"
+	req.Streaming = false
+	stream, err := c.infer(ctx, req)
+	if err != nil {
+		return Completion{}, fmt.Errorf("could not complete: %w", err)
 	}
-	url := fmt.Sprintf("http://%s/completion", c.Addr)
+	return collect(ctx, stream)
+}
 
-	reqBody, err := json.Marshal(req)
+// request returns completion request for the prompt.
+func (c *Client) request(p Prompt) (completionRequest, error) {
+	options := c.options()
+	if strings.EqualFold(p.Format, FormatAuto) {
+		format, err := DetectFormat(options.ModelPath)
+		if err != nil {
+			return completionRequest{}, err
+		}
+		p.Format = format
+	}
+	prompt, err := p.Render()
 	if err != nil {
-		return nil, fmt.Errorf("completion request cannot be serialized: %w", err)
+		return completionRequest{}, err
+	}
+	return completionRequest{
+		Prompt:           prompt,
+		Temp:             options.Temp,
+		TopK:             options.TopK,
+		MinP:             options.MinP,
+		TopP:             options.TopP,
+		TypicalP:         options.TypicalP,
+		Seed:             int(options.Seed),
+		PredictNum:       options.MaxTokens,
+		RepeatPenalty:    options.RepeatPenalty,
+		RepeatLastN:      options.RepeatLastN,
+		PresencePenalty:  options.PresencePenalty,
+		FrequencyPenalty: options.FrequencyPenalty,
+		Mirostat:         options.Mirostat,
+		MirostatTau:      options.MirostatTau,
+		MirostatEta:      options.MirostatEta,
+		Stop:             stopStrings(options.Stop, p.Stop()),
+		Grammar:          options.Grammar,
+		JSONSchema:       options.JSONSchema,
+		WithoutNewlines:  false,
+	}, nil
+}
+
+// stopStrings returns unique stop strings from all given lists.
+func stopStrings(lists ...[]string) []string {
+	var stop []string
+	for _, list := range lists {
+		for _, s := range list {
+			if s != "" && !slices.Contains(stop, s) {
+				stop = append(stop, s)
+			}
+		}
 	}
+	return stop
+}
 
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(reqBody))
+// infer is a low-level function for sending completion requests to the LLM server.
+func (c *Client) infer(ctx context.Context, req completionRequest) (*Stream, error) {
+	release, err := c.acquireSlot(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("completion request cannot be created: %w", err)
+		return nil, fmt.Errorf("cannot wait for free slot of LLM server: %w", err)
 	}
-	c.Logger.Info("completion request", slog.String("url", url), slog.String("body", string(reqBody)))
-	resp, err := http.DefaultClient.Do(httpReq)
+	resp, err := c.send(ctx, http.MethodPost, "/completion", req)
 	if err != nil {
-		return nil, fmt.Errorf("completion request cannot be sent: %w", err)
+		release()
+		return nil, err
 	}
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("LLM server returned error: %s", resp.Status)
+
+	// slot is used until the end of the stream
+	body := slotCloser{Closer: resp.Body, release: release}
+	if !req.Streaming {
+		return newStream(body, decodeResponse(resp.Body), req.Stop), nil
 	}
+	return newStream(body, decodeEvents(resp.Body), req.Stop), nil
+}
+
+// slotCloser releases the slot of LLM server after closing the response body.
+type slotCloser struct {
+	io.Closer
+	release func()
+}
+
+func (s slotCloser) Close() error {
+	defer s.release()
+	return s.Closer.Close()
+}
+
+// serverError represents an error reported by the LLM server in the stream.
+type serverError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Content string `json:"content"`
+}
+
+func (e serverError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = e.Content
+	}
+	if e.Code != 0 {
+		return fmt.Sprintf("LLM server returned error: %d %s", e.Code, msg)
+	}
+	return fmt.Sprintf("LLM server returned error: %s", msg)
+}
+
+// serverResponse represents completion response or error from LLM server.
+type serverResponse struct {
+	completionResponse
+	Error *serverError `json:"error"`
+}
 
-	ch := make(chan string)
-	go func(respBody io.ReadCloser) {
-		defer close(ch)
-		defer respBody.Close()
+// decodeResponse returns a function which decodes a single (non-streamed)
+// completion response.
+func decodeResponse(r io.Reader) func() (chunk, error) {
+	decoded := false
+	return func() (chunk, error) {
+		if decoded {
+			return chunk{}, io.EOF
+		}
+		decoded = true
 
-		scanner := bufio.NewScanner(respBody)
+		var response serverResponse
+		if err := json.NewDecoder(r).Decode(&response); err != nil {
+			return chunk{}, fmt.Errorf("cannot decode server response: %w", err)
+		}
+		if response.Error != nil {
+			return chunk{}, *response.Error
+		}
+		result := response.result()
+		return chunk{content: response.Content, final: &result}, nil
+	}
+}
+
+// decodeEvents returns a function which decodes server-sent events with
+// completion responses. The stream ending without the final response is
+// reported as io.ErrUnexpectedEOF.
+func decodeEvents(r io.Reader) func() (chunk, error) {
+	scanner := newLineScanner(r)
+	return func() (chunk, error) {
 		for scanner.Scan() {
 			line := scanner.Bytes()
-
-			var response completionResponse
-			if bytes.HasPrefix(line, []byte("data: ")) {
-				json.Unmarshal(line[6:], &response)
-			} else {
-				json.Unmarshal(line, &response)
+			if len(line) == 0 || line[0] == ':' {
+				// events separator or comment
+				continue
 			}
-
-			if response.Stop {
-				return
+			if msg, ok := bytes.CutPrefix(line, []byte("error: ")); ok {
+				e := serverError{Message: string(msg)}
+				json.Unmarshal(msg, &e)
+				return chunk{}, e
 			}
+			line = bytes.TrimPrefix(line, []byte("data: "))
 
-			if response.Content == "" {
-				continue
+			var response serverResponse
+			if err := json.Unmarshal(line, &response); err != nil {
+				return chunk{}, fmt.Errorf("cannot decode server event: %w", err)
+			}
+			if response.Error != nil {
+				return chunk{}, *response.Error
 			}
 
-			ch <- response.Content
+			if response.Stop {
+				result := response.result()
+				return chunk{content: response.Content, final: &result}, nil
+			}
+			return chunk{content: response.Content}, nil
 		}
-	}(resp.Body)
-
-	return ch, nil
+		return chunk{}, scanErr(scanner)
+	}
 }
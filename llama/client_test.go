@@ -2,8 +2,20 @@ package llama
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestComplete(t *testing.T) {
@@ -34,3 +46,339 @@ func TestComplete(t *testing.T) {
 	}
 
 }
+
+func TestClientComplete_Options(t *testing.T) {
+	var got completionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("cannot decode request: %v", err)
+		}
+		fmt.Fprintln(w, `data: {"content":"Hi","stop":false}`)
+		fmt.Fprintln(w, `data: {"content":"","stop":true}`)
+	}))
+	defer server.Close()
+
+	options := DefaultOptions
+	options.TopK = 40
+	options.TopP = 0.9
+	options.MaxTokens = 16
+	options.Stop = []string{"###"}
+	options.Seed = 42
+	options.Grammar = `root ::= "yes" | "no"`
+	client := Client{Addr: strings.TrimPrefix(server.URL, "http://"), Options: &options}
+	c, err := client.Complete(context.TODO(), Prompt{})
+	if err != nil {
+		t.Fatalf("client.Complete() returns error: %v", err)
+	}
+	for range c {
+	}
+
+	want := completionRequest{
+		Prompt:        "\n",
+		Temp:          1,
+		TopK:          40,
+		TopP:          0.9,
+		TypicalP:      1,
+		Seed:          42,
+		RepeatPenalty: 1,
+		MirostatTau:   5,
+		MirostatEta:   0.1,
+		PredictNum:    16,
+		Stop:          []string{"###"},
+		Grammar:       `root ::= "yes" | "no"`,
+		Streaming:     true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("client.Complete() sends %+v, want %+v", got, want)
+	}
+}
+
+func TestClientComplete_Stop(t *testing.T) {
+	var got completionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("cannot decode request: %v", err)
+		}
+		// server ignores stop string split between tokens
+		for _, token := range []string{"Fine", ".<|im", "_end|>", "<|im_start|>user"} {
+			fmt.Fprintf(w, "data: {\"content\":%q,\"stop\":false}\n", token)
+		}
+		fmt.Fprintln(w, `data: {"content":"","stop":true}`)
+	}))
+	defer server.Close()
+
+	options := DefaultOptions
+	options.Stop = []string{"END"}
+	client := Client{Addr: strings.TrimPrefix(server.URL, "http://"), Options: &options}
+	c, err := client.Complete(context.TODO(), Prompt{Format: "chatml"})
+	if err != nil {
+		t.Fatalf("client.Complete() returns error: %v", err)
+	}
+	result := strings.Builder{}
+	for s := range c {
+		result.WriteString(s)
+	}
+
+	if result.String() != "Fine." {
+		t.Fatalf("client.Complete() = %q, want %q", result.String(), "Fine.")
+	}
+	wantStop := []string{"END", "<|im_end|>", "<|im_start|>"}
+	if !reflect.DeepEqual(got.Stop, wantStop) {
+		t.Fatalf("client.Complete() sends stop %q, want %q", got.Stop, wantStop)
+	}
+}
+
+func TestClientCompleteText(t *testing.T) {
+	var got completionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("cannot decode request: %v", err)
+		}
+		fmt.Fprintln(w, `{"content":"Fine.<|im_end|>","stop":true,"tokens_evaluated":7,"tokens_predicted":3,"stopped_eos":true}`)
+	}))
+	defer server.Close()
+
+	client := Client{Addr: strings.TrimPrefix(server.URL, "http://"), Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	completion, err := client.CompleteText(context.TODO(), Prompt{Format: "chatml"})
+	if err != nil {
+		t.Fatalf("client.CompleteText() returns error: %v", err)
+	}
+
+	if got.Streaming {
+		t.Errorf("client.CompleteText() sends stream = %v, want %v", got.Streaming, false)
+	}
+	want := Completion{
+		Text: "Fine.",
+		Result: Result{
+			TokensEvaluated: 7,
+			TokensPredicted: 3,
+			StopReason:      StopWord,
+			StoppingWord:    "<|im_end|>",
+		},
+	}
+	if !reflect.DeepEqual(completion, want) {
+		t.Fatalf("client.CompleteText() = %+v, want %+v", completion, want)
+	}
+}
+
+func TestClientCompleteText_Cancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// disconnection is detected after reading the whole request
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	client := Client{Addr: strings.TrimPrefix(server.URL, "http://"), Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	_, err := client.CompleteText(ctx, Prompt{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("client.CompleteText() returns error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestClientComplete_Slots(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprintln(w, `data: {"content":"Hi","stop":false}`)
+		fmt.Fprintln(w, `data: {"content":"","stop":true}`)
+	}))
+	defer server.Close()
+
+	client := Client{
+		Addr:   strings.TrimPrefix(server.URL, "http://"),
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Slots:  2,
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := client.Complete(context.TODO(), Prompt{})
+			if err != nil {
+				t.Errorf("client.Complete() returns error: %v", err)
+				return
+			}
+			for range c {
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxRunning.Load() != 2 {
+		t.Fatalf("client.Complete() sends %d concurrent requests, want %d", maxRunning.Load(), 2)
+	}
+}
+
+func TestClientComplete_SharedSlots(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			m := maxRunning.Load()
+			if n <= m || maxRunning.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		<-release
+		fmt.Fprint(w, `{"content":"","stop":true}`)
+	}))
+	defer server.Close()
+
+	addr := strings.TrimPrefix(server.URL, "http://")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	first := Client{Addr: addr, Logger: logger, Slots: 1}
+	other := Client{Addr: addr, Logger: logger, Slots: 3}
+
+	wg := sync.WaitGroup{}
+	for _, client := range []*Client{&first, &other, &other, &other} {
+		client := client
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.CompleteText(context.TODO(), Prompt{}); err != nil {
+				t.Errorf("client.CompleteText() returns error: %v", err)
+			}
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if maxRunning.Load() != 1 {
+		t.Fatalf("clients with the same Addr send %d concurrent requests, want %d", maxRunning.Load(), 1)
+	}
+}
+
+func TestClient_Remote(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"content":"Hi","stop":true}`)
+	}))
+	defer server.Close()
+
+	client := Client{
+		Addr:       server.URL,
+		APIKey:     "secret",
+		HTTPClient: server.Client(),
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	got, err := client.CompleteText(context.TODO(), Prompt{})
+	if err != nil {
+		t.Fatalf("client.CompleteText() returns error: %v", err)
+	}
+	if got.Text != "Hi" {
+		t.Fatalf("client.CompleteText() = %q, want %q", got.Text, "Hi")
+	}
+
+	client.APIKey = "invalid"
+	if _, err := client.CompleteText(context.TODO(), Prompt{}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("client.CompleteText() returns error %v, want %q", err, "401 Unauthorized")
+	}
+}
+
+func TestClientProps(t *testing.T) {
+	testcases := []struct {
+		name  string
+		props string
+		want  ServerProps
+	}{
+		{"current", `{"model_path": "/models/a.gguf", "total_slots": 4, "default_generation_settings": {"model": "/models/a.gguf"}}`, ServerProps{ModelPath: "/models/a.gguf", TotalSlots: 4}},
+		{"old", `{"total_slots": 1, "default_generation_settings": {"model": "/models/b.gguf"}}`, ServerProps{ModelPath: "/models/b.gguf", TotalSlots: 1}},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/props" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				fmt.Fprint(w, tc.props)
+			}))
+			defer server.Close()
+
+			client := Client{Addr: server.URL}
+			got, err := client.Props(context.TODO())
+			if err != nil {
+				t.Fatalf("client.Props() returns error: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("client.Props() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClientTokenize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Content string `json:"content"`
+		}
+		if r.URL.Path != "/tokenize" || json.NewDecoder(r.Body).Decode(&req) != nil || req.Content != "Hello world" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"tokens": [15043, 3186]}`)
+	}))
+	defer server.Close()
+
+	client := Client{Addr: server.URL}
+	got, err := client.Tokenize(context.TODO(), "Hello world")
+	if err != nil {
+		t.Fatalf("client.Tokenize() returns error: %v", err)
+	}
+	if want := []int{15043, 3186}; !slices.Equal(got, want) {
+		t.Fatalf("client.Tokenize() = %v, want %v", got, want)
+	}
+}
+
+func TestClientEmbed(t *testing.T) {
+	testcases := []struct {
+		name     string
+		response string
+	}{
+		{"single", `{"embedding": [0.5, -0.25]}`},
+		{"list", `[{"index": 0, "embedding": [[0.5, -0.25]]}]`},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/embedding" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				fmt.Fprint(w, tc.response)
+			}))
+			defer server.Close()
+
+			client := Client{Addr: server.URL}
+			got, err := client.Embed(context.TODO(), "Hello")
+			if err != nil {
+				t.Fatalf("client.Embed() returns error: %v", err)
+			}
+			if want := []float32{0.5, -0.25}; !slices.Equal(got, want) {
+				t.Fatalf("client.Embed() = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package llama
+
+import "context"
+
+// Completer is implemented by clients of LLM backends. Client implements
+// it for the llama.cpp server.
+type Completer interface {
+	// CompleteText returns the whole answer for the prompt.
+	CompleteText(ctx context.Context, p Prompt) (Completion, error)
+
+	// CompleteStream returns a Stream with the answer for the prompt. The
+	// caller must close the stream.
+	CompleteStream(ctx context.Context, p Prompt) (*Stream, error)
+
+	// Tokenize returns tokens of the text in the vocabulary of the model.
+	Tokenize(ctx context.Context, text string) ([]int, error)
+
+	// Embed returns the embedding vector of the text.
+	Embed(ctx context.Context, text string) ([]float32, error)
+}
+
+var _ Completer = (*Client)(nil)
@@ -0,0 +1,54 @@
+//go:build linux
+
+package llama
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// physicalCores returns the number of physical CPU cores. Hyper-threading
+// does not speed up generation, so logical CPUs are not counted.
+func physicalCores() int {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return runtime.NumCPU()
+	}
+	defer f.Close()
+	if n := countCores(f); n > 0 {
+		return n
+	}
+	return runtime.NumCPU()
+}
+
+// countCores returns the number of unique cores listed in /proc/cpuinfo.
+// It returns 0 if the cores are not described (e.g. on some ARM boards).
+func countCores(r io.Reader) int {
+	cores := make(map[[2]string]struct{})
+	var physicalID, coreID string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			// processors are separated by empty lines
+			if coreID != "" {
+				cores[[2]string{physicalID, coreID}] = struct{}{}
+			}
+			physicalID, coreID = "", ""
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "physical id":
+			physicalID = strings.TrimSpace(value)
+		case "core id":
+			coreID = strings.TrimSpace(value)
+		}
+	}
+	if coreID != "" {
+		cores[[2]string{physicalID, coreID}] = struct{}{}
+	}
+	return len(cores)
+}
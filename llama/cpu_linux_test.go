@@ -0,0 +1,27 @@
+package llama
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCountCores(t *testing.T) {
+	testcases := []struct {
+		name    string
+		cpuinfo string
+		want    int
+	}{
+		{"hyper-threading", "processor\t: 0\nphysical id\t: 0\ncore id\t\t: 0\n\nprocessor\t: 1\nphysical id\t: 0\ncore id\t\t: 1\n\nprocessor\t: 2\nphysical id\t: 0\ncore id\t\t: 0\n\nprocessor\t: 3\nphysical id\t: 0\ncore id\t\t: 1\n", 2},
+		{"two sockets", "processor\t: 0\nphysical id\t: 0\ncore id\t\t: 0\n\nprocessor\t: 1\nphysical id\t: 1\ncore id\t\t: 0\n", 2},
+		{"no cores", "processor\t: 0\nBogoMIPS\t: 48.00\n\nprocessor\t: 1\nBogoMIPS\t: 48.00\n", 0},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := countCores(strings.NewReader(tc.cpuinfo)); got != tc.want {
+				t.Fatalf("countCores() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
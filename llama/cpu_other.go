@@ -0,0 +1,11 @@
+//go:build !linux
+
+package llama
+
+import "runtime"
+
+// physicalCores returns the number of CPU cores. On this platform, logical
+// CPUs are counted.
+func physicalCores() int {
+	return runtime.NumCPU()
+}
@@ -0,0 +1,506 @@
+package llama
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ggufMagic is a magic number at the beginning of GGUF file ("GGUF" in
+// little-endian).
+const ggufMagic = 0x46554747
+
+// types of metadata values in GGUF file
+const (
+	ggufTypeUint8 uint32 = iota
+	ggufTypeInt8
+	ggufTypeUint16
+	ggufTypeInt16
+	ggufTypeUint32
+	ggufTypeInt32
+	ggufTypeFloat32
+	ggufTypeBool
+	ggufTypeString
+	ggufTypeArray
+	ggufTypeUint64
+	ggufTypeInt64
+	ggufTypeFloat64
+)
+
+// limits protecting from huge allocations caused by corrupted files
+const (
+	ggufMaxStringLen = 1 << 20 // chat templates have tens of kilobytes
+	ggufMaxArrayLen  = 1 << 21 // vocabularies have about 256k tokens
+	ggufMaxEntries   = 1 << 16 // metadata entries and tensors
+	ggufMaxDims      = 8
+
+	// ggufAllocHint limits capacity preallocated from counts read from
+	// the file, which is not verified before reading all entries
+	ggufAllocHint = 1024
+)
+
+// ErrInvalidGGUF is returned when a file is not a valid GGUF file.
+var ErrInvalidGGUF = errors.New("invalid GGUF file")
+
+// GGUF represents the header of a model file in GGUF format.
+//
+// See: https://github.com/ggerganov/ggml/blob/master/docs/gguf.md
+type GGUF struct {
+	// Version specifies version of the file format.
+	Version uint32
+
+	// Metadata contains key-value pairs from the file header. Values are
+	// Go equivalents of GGUF types (arrays are stored as []any).
+	Metadata map[string]any
+
+	// Tensors contains descriptions of tensors stored in the file.
+	Tensors []TensorInfo
+}
+
+// TensorInfo represents a description of a tensor stored in GGUF file.
+type TensorInfo struct {
+	Name       string
+	Dimensions []uint64
+	Type       uint32
+	Offset     uint64
+}
+
+// names of tensor types (see: ggml_type in ggml.h)
+var tensorTypes = map[uint32]string{
+	0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 6: "Q5_0", 7: "Q5_1", 8: "Q8_0",
+	9: "Q8_1", 10: "Q2_K", 11: "Q3_K", 12: "Q4_K", 13: "Q5_K", 14: "Q6_K",
+	15: "Q8_K", 16: "IQ2_XXS", 17: "IQ2_XS", 18: "IQ3_XXS", 19: "IQ1_S",
+	20: "IQ4_NL", 21: "IQ3_S", 22: "IQ2_S", 23: "IQ4_XS", 24: "I8", 25: "I16",
+	26: "I32", 27: "I64", 28: "F64", 29: "IQ1_M", 30: "BF16",
+}
+
+// names of model file types (see: llama_ftype in llama.h)
+var fileTypes = map[uint64]string{
+	0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 4: "Q4_1_SOME_F16", 7: "Q8_0",
+	8: "Q5_0", 9: "Q5_1", 10: "Q2_K", 11: "Q3_K_S", 12: "Q3_K_M",
+	13: "Q3_K_L", 14: "Q4_K_S", 15: "Q4_K_M", 16: "Q5_K_S", 17: "Q5_K_M",
+	18: "Q6_K", 19: "IQ2_XXS", 20: "IQ2_XS", 21: "Q2_K_S", 22: "IQ3_XS",
+	23: "IQ3_XXS", 24: "IQ1_S", 25: "IQ4_NL", 26: "IQ3_S", 27: "IQ3_M",
+	28: "IQ2_S", 29: "IQ2_M", 30: "IQ4_XS", 31: "IQ1_M", 32: "BF16",
+}
+
+// TypeName returns the name of tensor type (e.g. "Q4_K").
+func (t TensorInfo) TypeName() string {
+	if name, ok := tensorTypes[t.Type]; ok {
+		return name
+	}
+	return fmt.Sprintf("type(%d)", t.Type)
+}
+
+// Elements returns the number of elements in the tensor.
+func (t TensorInfo) Elements() uint64 {
+	n := uint64(1)
+	for _, dim := range t.Dimensions {
+		n *= dim
+	}
+	return n
+}
+
+// ReadModelFile reads the GGUF header of the model file.
+func ReadModelFile(path string) (GGUF, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return GGUF{}, fmt.Errorf("cannot read model: %w", err)
+	}
+	defer f.Close()
+
+	gguf, err := ReadGGUF(bufio.NewReader(f))
+	if err != nil {
+		return GGUF{}, fmt.Errorf("cannot read model '%s': %w", path, err)
+	}
+	return gguf, nil
+}
+
+// ReadGGUF reads the GGUF header (metadata and tensor descriptions) from r.
+func ReadGGUF(r io.Reader) (GGUF, error) {
+	d := ggufDecoder{r: r}
+
+	magic, err := d.uint32()
+	if err != nil {
+		return GGUF{}, err
+	}
+	if magic != ggufMagic {
+		return GGUF{}, fmt.Errorf("%w: unknown magic number 0x%08x", ErrInvalidGGUF, magic)
+	}
+
+	gguf := GGUF{}
+	if gguf.Version, err = d.uint32(); err != nil {
+		return GGUF{}, err
+	}
+	switch gguf.Version {
+	case 1:
+		d.v1 = true
+	case 2, 3:
+		// 64-bit lengths
+	default:
+		return GGUF{}, fmt.Errorf("%w: unsupported version %d", ErrInvalidGGUF, gguf.Version)
+	}
+
+	tensorCount, err := d.length()
+	if err != nil {
+		return GGUF{}, err
+	}
+	kvCount, err := d.length()
+	if err != nil {
+		return GGUF{}, err
+	}
+	if tensorCount > ggufMaxEntries || kvCount > ggufMaxEntries {
+		return GGUF{}, fmt.Errorf("%w: too many entries", ErrInvalidGGUF)
+	}
+
+	gguf.Metadata = make(map[string]any, min(kvCount, ggufAllocHint))
+	for i := uint64(0); i < kvCount; i++ {
+		key, err := d.string()
+		if err != nil {
+			return GGUF{}, err
+		}
+		valueType, err := d.uint32()
+		if err != nil {
+			return GGUF{}, err
+		}
+		value, err := d.value(valueType)
+		if err != nil {
+			return GGUF{}, fmt.Errorf("cannot read value of '%s': %w", key, err)
+		}
+		gguf.Metadata[key] = value
+	}
+
+	gguf.Tensors = make([]TensorInfo, 0, min(tensorCount, ggufAllocHint))
+	for i := uint64(0); i < tensorCount; i++ {
+		tensor := TensorInfo{}
+		if tensor.Name, err = d.string(); err != nil {
+			return GGUF{}, err
+		}
+		dims, err := d.uint32()
+		if err != nil {
+			return GGUF{}, err
+		}
+		if dims > ggufMaxDims {
+			return GGUF{}, fmt.Errorf("%w: tensor '%s' has %d dimensions", ErrInvalidGGUF, tensor.Name, dims)
+		}
+		tensor.Dimensions = make([]uint64, dims)
+		for j := range tensor.Dimensions {
+			if tensor.Dimensions[j], err = d.length(); err != nil {
+				return GGUF{}, err
+			}
+		}
+		if tensor.Type, err = d.uint32(); err != nil {
+			return GGUF{}, err
+		}
+		if tensor.Offset, err = d.uint64(); err != nil {
+			return GGUF{}, err
+		}
+		gguf.Tensors = append(gguf.Tensors, tensor)
+	}
+
+	return gguf, nil
+}
+
+// Architecture returns the name of model architecture (e.g. "llama").
+func (g GGUF) Architecture() string {
+	s, _ := g.Metadata["general.architecture"].(string)
+	return s
+}
+
+// ContextLength returns the context length used during model training.
+// Returns 0 if unknown.
+func (g GGUF) ContextLength() uint64 {
+	n, _ := toUint64(g.Metadata[g.Architecture()+".context_length"])
+	return n
+}
+
+// Name returns the model name provided by the model author.
+func (g GGUF) Name() string {
+	s, _ := g.Metadata["general.name"].(string)
+	return s
+}
+
+// FileType returns the quantization type of the model (e.g. "Q4_K_M").
+// Returns empty string if unknown.
+func (g GGUF) FileType() string {
+	n, ok := toUint64(g.Metadata["general.file_type"])
+	if !ok {
+		return ""
+	}
+	if name, ok := fileTypes[n]; ok {
+		return name
+	}
+	return fmt.Sprintf("type(%d)", n)
+}
+
+// ParameterCount returns the number of model parameters (elements of all
+// tensors).
+func (g GGUF) ParameterCount() uint64 {
+	n := uint64(0)
+	for _, tensor := range g.Tensors {
+		n += tensor.Elements()
+	}
+	return n
+}
+
+// VocabularySize returns the number of tokens known by the tokenizer.
+func (g GGUF) VocabularySize() int {
+	tokens, _ := g.Metadata["tokenizer.ggml.tokens"].([]any)
+	return len(tokens)
+}
+
+// ChatTemplate returns the chat template (in Jinja format) provided by the
+// model author.
+func (g GGUF) ChatTemplate() string {
+	s, _ := g.Metadata["tokenizer.chat_template"].(string)
+	return s
+}
+
+// SpecialTokens returns special tokens (e.g. "bos", "eos") defined in the
+// tokenizer.
+func (g GGUF) SpecialTokens() map[string]string {
+	tokens, _ := g.Metadata["tokenizer.ggml.tokens"].([]any)
+	special := make(map[string]string)
+	for name, key := range map[string]string{
+		"bos": "tokenizer.ggml.bos_token_id",
+		"eos": "tokenizer.ggml.eos_token_id",
+		"unk": "tokenizer.ggml.unknown_token_id",
+		"sep": "tokenizer.ggml.separator_token_id",
+		"pad": "tokenizer.ggml.padding_token_id",
+	} {
+		id, ok := toUint64(g.Metadata[key])
+		if !ok || id >= uint64(len(tokens)) {
+			continue
+		}
+		if token, ok := tokens[id].(string); ok {
+			special[name] = token
+		}
+	}
+	return special
+}
+
+// PromptFormat returns the name of built-in prompt format matching the chat
+// template of the model.
+func (g GGUF) PromptFormat() (string, bool) {
+	tmpl := g.ChatTemplate()
+	switch {
+	case tmpl == "":
+		return "", false
+	case strings.Contains(tmpl, "<|im_start|>"):
+		return "chatml", true
+	case strings.Contains(tmpl, "<|start_header_id|>"):
+		return "llama3", true
+	case strings.Contains(tmpl, "<start_of_turn>"):
+		return "gemma", true
+	case strings.Contains(tmpl, "[INST]"):
+		return "mistral", true
+	case strings.Contains(tmpl, "GPT4 Correct "):
+		return "openchat", true
+	case strings.Contains(tmpl, "<|user|>") && !strings.Contains(tmpl, "<|end|>"):
+		return "zephyr", true
+	case strings.Contains(tmpl, "### Instruction"):
+		return "alpaca", true
+	default:
+		return "", false
+	}
+}
+
+// detectedFormats caches prompt formats of model files, because reading the
+// header (with the whole vocabulary) is slow.
+var (
+	detectedFormatsMu sync.Mutex
+	detectedFormats   = map[detectedFormatKey]string{}
+)
+
+// detectedFormatKey identifies the version of the model file.
+type detectedFormatKey struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// DetectFormat returns the name of prompt format matching the chat template
+// stored in the model file. The result is cached until the file is modified.
+func DetectFormat(modelPath string) (string, error) {
+	info, err := os.Stat(modelPath)
+	if err != nil {
+		return "", fmt.Errorf("cannot detect prompt format: %w", err)
+	}
+	key := detectedFormatKey{path: modelPath, size: info.Size(), modTime: info.ModTime()}
+	detectedFormatsMu.Lock()
+	format, ok := detectedFormats[key]
+	detectedFormatsMu.Unlock()
+	if ok {
+		return format, nil
+	}
+
+	gguf, err := ReadModelFile(modelPath)
+	if err != nil {
+		return "", fmt.Errorf("cannot detect prompt format: %w", err)
+	}
+	format, ok = gguf.PromptFormat()
+	if !ok {
+		return "", fmt.Errorf("cannot detect prompt format: chat template of model '%s' is unknown", modelPath)
+	}
+
+	detectedFormatsMu.Lock()
+	defer detectedFormatsMu.Unlock()
+	detectedFormats[key] = format
+	return format, nil
+}
+
+// ggufDecoder reads little-endian GGUF primitives.
+type ggufDecoder struct {
+	r  io.Reader
+	v1 bool // version 1 uses 32-bit lengths
+}
+
+func (d *ggufDecoder) read(v any) error {
+	if err := binary.Read(d.r, binary.LittleEndian, v); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("%w: %w", ErrInvalidGGUF, err)
+	}
+	return nil
+}
+
+func (d *ggufDecoder) uint32() (uint32, error) {
+	var v uint32
+	err := d.read(&v)
+	return v, err
+}
+
+func (d *ggufDecoder) uint64() (uint64, error) {
+	var v uint64
+	err := d.read(&v)
+	return v, err
+}
+
+// length reads a size field, which is 32-bit in version 1 of the format.
+func (d *ggufDecoder) length() (uint64, error) {
+	if d.v1 {
+		v, err := d.uint32()
+		return uint64(v), err
+	}
+	return d.uint64()
+}
+
+func (d *ggufDecoder) string() (string, error) {
+	n, err := d.length()
+	if err != nil {
+		return "", err
+	}
+	if n > ggufMaxStringLen {
+		return "", fmt.Errorf("%w: string too long (%d bytes)", ErrInvalidGGUF, n)
+	}
+	buf := make([]byte, n)
+	if err := d.read(buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func (d *ggufDecoder) value(valueType uint32) (any, error) {
+	switch valueType {
+	case ggufTypeUint8:
+		var v uint8
+		err := d.read(&v)
+		return v, err
+	case ggufTypeInt8:
+		var v int8
+		err := d.read(&v)
+		return v, err
+	case ggufTypeUint16:
+		var v uint16
+		err := d.read(&v)
+		return v, err
+	case ggufTypeInt16:
+		var v int16
+		err := d.read(&v)
+		return v, err
+	case ggufTypeUint32:
+		var v uint32
+		err := d.read(&v)
+		return v, err
+	case ggufTypeInt32:
+		var v int32
+		err := d.read(&v)
+		return v, err
+	case ggufTypeFloat32:
+		var v float32
+		err := d.read(&v)
+		return v, err
+	case ggufTypeBool:
+		var v uint8
+		err := d.read(&v)
+		return v != 0, err
+	case ggufTypeString:
+		return d.string()
+	case ggufTypeUint64:
+		var v uint64
+		err := d.read(&v)
+		return v, err
+	case ggufTypeInt64:
+		var v int64
+		err := d.read(&v)
+		return v, err
+	case ggufTypeFloat64:
+		var v float64
+		err := d.read(&v)
+		return v, err
+	case ggufTypeArray:
+		elemType, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		n, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		if n > ggufMaxArrayLen {
+			return nil, fmt.Errorf("%w: array too long (%d elements)", ErrInvalidGGUF, n)
+		}
+		values := make([]any, 0, min(n, ggufAllocHint))
+		for i := uint64(0); i < n; i++ {
+			v, err := d.value(elemType)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown value type %d", ErrInvalidGGUF, valueType)
+	}
+}
+
+// toUint64 converts integer metadata value to uint64.
+func toUint64(v any) (uint64, bool) {
+	switch n := v.(type) {
+	case uint8:
+		return uint64(n), true
+	case uint16:
+		return uint64(n), true
+	case uint32:
+		return uint64(n), true
+	case uint64:
+		return n, true
+	case int8:
+		return uint64(n), n >= 0
+	case int16:
+		return uint64(n), n >= 0
+	case int32:
+		return uint64(n), n >= 0
+	case int64:
+		return uint64(n), n >= 0
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,246 @@
+package llama
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// ggufKV represents a metadata entry of synthetic GGUF file.
+type ggufKV struct {
+	key   string
+	value any
+}
+
+// encodeGGUF returns synthetic GGUF file (version 3) with given metadata and
+// tensors.
+func encodeGGUF(t *testing.T, kvs []ggufKV, tensors []TensorInfo) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	write := func(v any) {
+		if err := binary.Write(buf, binary.LittleEndian, v); err != nil {
+			t.Fatalf("cannot encode GGUF: %v", err)
+		}
+	}
+	writeString := func(s string) {
+		write(uint64(len(s)))
+		buf.WriteString(s)
+	}
+	var writeValue func(v any)
+	writeValue = func(v any) {
+		switch v := v.(type) {
+		case uint32:
+			write(ggufTypeUint32)
+			write(v)
+		case uint64:
+			write(ggufTypeUint64)
+			write(v)
+		case float32:
+			write(ggufTypeFloat32)
+			write(v)
+		case bool:
+			write(ggufTypeBool)
+			write(v)
+		case string:
+			write(ggufTypeString)
+			writeString(v)
+		case []string:
+			write(ggufTypeArray)
+			write(ggufTypeString)
+			write(uint64(len(v)))
+			for _, s := range v {
+				writeString(s)
+			}
+		default:
+			t.Fatalf("cannot encode GGUF value of type %T", v)
+		}
+	}
+
+	write(uint32(ggufMagic))
+	write(uint32(3))
+	write(uint64(len(tensors)))
+	write(uint64(len(kvs)))
+	for _, kv := range kvs {
+		writeString(kv.key)
+		writeValue(kv.value)
+	}
+	for _, tensor := range tensors {
+		writeString(tensor.Name)
+		write(uint32(len(tensor.Dimensions)))
+		for _, dim := range tensor.Dimensions {
+			write(dim)
+		}
+		write(tensor.Type)
+		write(tensor.Offset)
+	}
+	return buf.Bytes()
+}
+
+// writeModel writes synthetic GGUF file to temporary directory and returns
+// its path.
+func writeModel(t *testing.T, kvs []ggufKV, tensors []TensorInfo) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "model.gguf")
+	if err := os.WriteFile(path, encodeGGUF(t, kvs, tensors), 0o600); err != nil {
+		t.Fatalf("cannot write model: %v", err)
+	}
+	return path
+}
+
+func TestReadGGUF(t *testing.T) {
+	kvs := []ggufKV{
+		{"general.architecture", "llama"},
+		{"general.name", "tiny"},
+		{"llama.context_length", uint32(2048)},
+		{"llama.rope.freq_base", float32(10000)},
+		{"tokenizer.ggml.add_bos_token", true},
+		{"tokenizer.ggml.tokens", []string{"<unk>", "<s>", "</s>", "a"}},
+		{"tokenizer.ggml.bos_token_id", uint32(1)},
+		{"tokenizer.ggml.eos_token_id", uint32(2)},
+		{"tokenizer.ggml.unknown_token_id", uint32(0)},
+		{"tokenizer.chat_template", "{% for message in messages %}<|im_start|>{{ message.role }}{% endfor %}"},
+		{"general.file_type", uint32(7)},
+	}
+	tensors := []TensorInfo{
+		{Name: "token_embd.weight", Dimensions: []uint64{64, 4}, Type: 8, Offset: 0},
+		{Name: "output_norm.weight", Dimensions: []uint64{64}, Type: 0, Offset: 256},
+	}
+
+	got, err := ReadGGUF(bytes.NewReader(encodeGGUF(t, kvs, tensors)))
+	if err != nil {
+		t.Fatalf("ReadGGUF() returns error: %v", err)
+	}
+
+	if got.Version != 3 {
+		t.Errorf("ReadGGUF().Version = %d, want %d", got.Version, 3)
+	}
+	if got.Architecture() != "llama" {
+		t.Errorf("ReadGGUF().Architecture() = %q, want %q", got.Architecture(), "llama")
+	}
+	if got.ContextLength() != 2048 {
+		t.Errorf("ReadGGUF().ContextLength() = %d, want %d", got.ContextLength(), 2048)
+	}
+	if got.Name() != "tiny" {
+		t.Errorf("ReadGGUF().Name() = %q, want %q", got.Name(), "tiny")
+	}
+	if got.FileType() != "Q8_0" {
+		t.Errorf("ReadGGUF().FileType() = %q, want %q", got.FileType(), "Q8_0")
+	}
+	if got.ParameterCount() != 320 {
+		t.Errorf("ReadGGUF().ParameterCount() = %d, want %d", got.ParameterCount(), 320)
+	}
+	if got.VocabularySize() != 4 {
+		t.Errorf("ReadGGUF().VocabularySize() = %d, want %d", got.VocabularySize(), 4)
+	}
+	if got.Tensors[0].TypeName() != "Q8_0" {
+		t.Errorf("ReadGGUF().Tensors[0].TypeName() = %q, want %q", got.Tensors[0].TypeName(), "Q8_0")
+	}
+	if got.Metadata["llama.rope.freq_base"] != float32(10000) {
+		t.Errorf("ReadGGUF().Metadata[\"llama.rope.freq_base\"] = %v, want %v", got.Metadata["llama.rope.freq_base"], float32(10000))
+	}
+	wantTokens := map[string]string{"bos": "<s>", "eos": "</s>", "unk": "<unk>"}
+	if !reflect.DeepEqual(got.SpecialTokens(), wantTokens) {
+		t.Errorf("ReadGGUF().SpecialTokens() = %v, want %v", got.SpecialTokens(), wantTokens)
+	}
+	if format, ok := got.PromptFormat(); !ok || format != "chatml" {
+		t.Errorf("ReadGGUF().PromptFormat() = %q, %v, want %q, true", format, ok, "chatml")
+	}
+	if !reflect.DeepEqual(got.Tensors, tensors) {
+		t.Errorf("ReadGGUF().Tensors = %v, want %v", got.Tensors, tensors)
+	}
+}
+
+func TestReadGGUF_Invalid(t *testing.T) {
+	valid := encodeGGUF(t, []ggufKV{{"general.architecture", "llama"}}, nil)
+	testcases := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"magic", []byte("GGML\x03\x00\x00\x00")},
+		{"version", []byte("GGUF\x09\x00\x00\x00")},
+		{"truncated", valid[:len(valid)-2]},
+		{"too many tensors", []byte("GGUF\x03\x00\x00\x00\x00\x00\xc0\x03\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")},
+		{"missing tensors", []byte("GGUF\x03\x00\x00\x00\x00\xf0\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")},
+		{"missing array elements", []byte("GGUF\x03\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00" +
+			"\x01\x00\x00\x00\x00\x00\x00\x00k\x09\x00\x00\x00\x04\x00\x00\x00\x00\x00\x10\x00\x00\x00\x00\x00")},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := ReadGGUF(bytes.NewReader(tc.data))
+			if !errors.Is(err, ErrInvalidGGUF) {
+				t.Fatalf("ReadGGUF(%q) want error %v, got: %v", tc.data, ErrInvalidGGUF, err)
+			}
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	testcases := []struct {
+		template string
+		want     string
+	}{
+		{"{% for message in messages %}{{'<|im_start|>' + message['role'] + '\n' + message['content'] + '<|im_end|>' + '\n'}}{% endfor %}", "chatml"},
+		{"{{ bos_token }}{% for message in messages %}{{ 'GPT4 Correct ' + message['role'].title() + ': ' + message['content'] + '<|end_of_turn|>'}}{% endfor %}", "openchat"},
+		{"{% for message in messages %}{% if message['role'] == 'user' %}{{ '<|user|>\n' + message['content'] + eos_token }}{% endif %}{% endfor %}", "zephyr"},
+		{"{% for message in messages %}{{ '<|start_header_id|>' + message['role'] + '<|end_header_id|>\n\n' + message['content'] | trim + '<|eot_id|>' }}{% endfor %}", "llama3"},
+		{"{{ bos_token }}{% for message in messages %}{% if message['role'] == 'user' %}{{ '[INST] ' + message['content'] + ' [/INST]' }}{% else %}{{ message['content'] + eos_token }}{% endif %}{% endfor %}", "mistral"},
+		{"{{ bos_token }}{% for message in messages %}{{ '<start_of_turn>' + role + '\n' + message['content'] | trim + '<end_of_turn>\n' }}{% endfor %}", "gemma"},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.want, func(t *testing.T) {
+			t.Parallel()
+			path := writeModel(t, []ggufKV{{"tokenizer.chat_template", tc.template}}, nil)
+			got, err := DetectFormat(path)
+			if err != nil {
+				t.Fatalf("DetectFormat() returns error: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("DetectFormat() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDetectFormat_Cache(t *testing.T) {
+	path := writeModel(t, []ggufKV{{"tokenizer.chat_template", "<|im_start|>"}}, nil)
+	if got, err := DetectFormat(path); got != "chatml" {
+		t.Fatalf("DetectFormat() = %q, %v, want %q", got, err, "chatml")
+	}
+
+	// the same size and modification time, so the file is not read again
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("cannot stat model: %v", err)
+	}
+	if err := os.WriteFile(path, make([]byte, info.Size()), 0o600); err != nil {
+		t.Fatalf("cannot write model: %v", err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("cannot change model time: %v", err)
+	}
+	if got, err := DetectFormat(path); got != "chatml" {
+		t.Fatalf("DetectFormat() = %q, %v, want cached %q", got, err, "chatml")
+	}
+
+	if err := os.WriteFile(path, encodeGGUF(t, []ggufKV{{"tokenizer.chat_template", "<start_of_turn>"}}, nil), 0o600); err != nil {
+		t.Fatalf("cannot write model: %v", err)
+	}
+	if got, err := DetectFormat(path); got != "gemma" {
+		t.Fatalf("DetectFormat() = %q, %v, want %q after modification", got, err, "gemma")
+	}
+}
+
+func TestDetectFormat_Unknown(t *testing.T) {
+	path := writeModel(t, []ggufKV{{"general.architecture", "llama"}}, nil)
+	if _, err := DetectFormat(path); err == nil {
+		t.Fatalf("DetectFormat() does not return error for model without chat template")
+	}
+}
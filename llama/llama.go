@@ -1,36 +1,104 @@
 package llama
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"slices"
 )
 
 var (
 	defaultServer = Server{Addr: "localhost:24114"}
 	defaultClient = Client{Addr: "localhost:24114"}
+	// defaultCompleter is used by package-level functions
+	defaultCompleter Completer = &defaultClient
 	// DefaultOptions represent neutral parameters for interacting with LLaMA model.
 	DefaultOptions = Options{
-		ModelPath: "",
-		Seed:      0,
-		Temp:      1,
-		MinP:      0,
+		ModelPath:        "",
+		Seed:             0,
+		Temp:             1,
+		MinP:             0,
+		TopK:             0,
+		TopP:             1,
+		TypicalP:         1,
+		RepeatPenalty:    1,
+		RepeatLastN:      0,
+		PresencePenalty:  0,
+		FrequencyPenalty: 0,
+		Mirostat:         0,
+		MirostatTau:      5,
+		MirostatEta:      0.1,
+		MaxTokens:        -1,
+		Stop:             nil,
+		Grammar:          "",
+		JSONSchema:       nil,
 	}
 )
 
-// SetDefault sets default Client and Server.
+// SetDefault sets default Client and Server. If the client has no address,
+// it connects to the server started by Serve. The client becomes the default
+// Completer.
 func SetDefault(server Server, client Client) {
 	defaultServer = server
 	defaultClient = client
+	defaultCompleter = &defaultClient
+}
+
+// SetCompleter sets the Completer used by package-level functions (e.g. other
+// backend or a fake in tests). If nil, the default Client is used.
+func SetCompleter(c Completer) {
+	if c == nil {
+		c = &defaultClient
+	}
+	defaultCompleter = c
 }
 
 // Serve starts LLM server and returns error if it fails. It is the caller's
 // responsibility to close Server.
 func Serve(ctx context.Context, modelPath string) error {
-	return defaultServer.Start(ctx, modelPath)
+	if err := defaultServer.Start(ctx, modelPath); err != nil {
+		return err
+	}
+	if defaultClient.Addr == "" {
+		defaultClient.Addr = defaultServer.Addr
+	}
+	return nil
 }
 
 // Complete returns a channel with completion results for given string.
 func Complete(ctx context.Context, p Prompt) (chan string, error) {
-	return defaultClient.Complete(ctx, p)
+	return complete(ctx, defaultCompleter, p)
+}
+
+// CompleteStream returns a Stream with completion results and metadata for
+// given string. It is the caller's responsibility to close Stream.
+func CompleteStream(ctx context.Context, p Prompt) (*Stream, error) {
+	return defaultCompleter.CompleteStream(ctx, p)
+}
+
+// CompleteText returns the whole answer with metadata for given string.
+func CompleteText(ctx context.Context, p Prompt) (Completion, error) {
+	return defaultCompleter.CompleteText(ctx, p)
+}
+
+// Tokenize returns tokens of the text in the vocabulary of the model.
+func Tokenize(ctx context.Context, text string) ([]int, error) {
+	return defaultCompleter.Tokenize(ctx, text)
+}
+
+// Embed returns the embedding vector of the text.
+func Embed(ctx context.Context, text string) ([]float32, error) {
+	return defaultCompleter.Embed(ctx, text)
+}
+
+// Done returns a channel which is closed when the default LLM server exits.
+func Done() <-chan struct{} {
+	return defaultServer.Done()
+}
+
+// Err returns *ExitError if the default LLM server exited unexpectedly.
+func Err() error {
+	return defaultServer.Err()
 }
 
 // Close releases all resources used by LLM server.
@@ -39,11 +107,55 @@ func Close() error {
 }
 
 // Options represent parameters for interacting with LLaMA model.
+//
+// See: https://github.com/ggerganov/llama.cpp/blob/master/examples/main/README.md#generation-flags
 type Options struct {
 	ModelPath string
-	Temp      float32
-	MinP      float32
-	Seed      uint
+
+	// Temp (temperature) scales probabilities of the next token. 1.0 means disable.
+	Temp float32
+	// MinP discards tokens less probable than MinP * probability of the most
+	// probable token. 0.0 means disable.
+	MinP float32
+	// TopK limits the next token to K most probable tokens. 0 means disable.
+	TopK int
+	// TopP limits the next token to the most probable tokens with cumulative
+	// probability P. 1.0 means disable.
+	TopP float32
+	// TypicalP enables locally typical sampling. 1.0 means disable.
+	TypicalP float32
+
+	// RepeatPenalty penalizes repetition of the last RepeatLastN tokens.
+	// 1.0 means disable.
+	RepeatPenalty float32
+	// RepeatLastN specifies the number of last tokens considered for
+	// penalties. 0 means disable, -1 means the context size.
+	RepeatLastN int
+	// PresencePenalty penalizes tokens which already appeared. 0.0 means disable.
+	PresencePenalty float32
+	// FrequencyPenalty penalizes tokens proportionally to the number of their
+	// appearances. 0.0 means disable.
+	FrequencyPenalty float32
+
+	// Mirostat specifies version of Mirostat sampling (1 or 2). 0 means disable.
+	Mirostat int
+	// MirostatTau specifies the target entropy of Mirostat sampling.
+	MirostatTau float32
+	// MirostatEta specifies the learning rate of Mirostat sampling.
+	MirostatEta float32
+
+	// MaxTokens limits the number of generated tokens. -1 means infinity.
+	MaxTokens int
+	// Stop specifies strings which end the generation.
+	Stop []string
+	// Seed specifies the seed of random number generator.
+	Seed uint
+
+	// Grammar specifies a grammar (in GBNF format) which constrains the output.
+	// See: https://github.com/ggerganov/llama.cpp/blob/master/grammars/README.md
+	Grammar string
+	// JSONSchema specifies a JSON Schema which constrains the output.
+	JSONSchema json.RawMessage
 }
 
 // Update updates the Options with the non-default values from other Options.
@@ -57,7 +169,49 @@ func (o *Options) Update(other Options) {
 	if other.MinP != DefaultOptions.MinP {
 		o.MinP = other.MinP
 	}
+	if other.TopK != DefaultOptions.TopK {
+		o.TopK = other.TopK
+	}
+	if other.TopP != DefaultOptions.TopP {
+		o.TopP = other.TopP
+	}
+	if other.TypicalP != DefaultOptions.TypicalP {
+		o.TypicalP = other.TypicalP
+	}
+	if other.RepeatPenalty != DefaultOptions.RepeatPenalty {
+		o.RepeatPenalty = other.RepeatPenalty
+	}
+	if other.RepeatLastN != DefaultOptions.RepeatLastN {
+		o.RepeatLastN = other.RepeatLastN
+	}
+	if other.PresencePenalty != DefaultOptions.PresencePenalty {
+		o.PresencePenalty = other.PresencePenalty
+	}
+	if other.FrequencyPenalty != DefaultOptions.FrequencyPenalty {
+		o.FrequencyPenalty = other.FrequencyPenalty
+	}
+	if other.Mirostat != DefaultOptions.Mirostat {
+		o.Mirostat = other.Mirostat
+	}
+	if other.MirostatTau != DefaultOptions.MirostatTau {
+		o.MirostatTau = other.MirostatTau
+	}
+	if other.MirostatEta != DefaultOptions.MirostatEta {
+		o.MirostatEta = other.MirostatEta
+	}
+	if other.MaxTokens != DefaultOptions.MaxTokens {
+		o.MaxTokens = other.MaxTokens
+	}
+	if !slices.Equal(other.Stop, DefaultOptions.Stop) {
+		o.Stop = other.Stop
+	}
 	if other.Seed != DefaultOptions.Seed {
 		o.Seed = other.Seed
 	}
+	if other.Grammar != DefaultOptions.Grammar {
+		o.Grammar = other.Grammar
+	}
+	if !bytes.Equal(other.JSONSchema, DefaultOptions.JSONSchema) {
+		o.JSONSchema = other.JSONSchema
+	}
 }
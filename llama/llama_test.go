@@ -0,0 +1,81 @@
+package llama
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOptionsUpdate(t *testing.T) {
+	options := DefaultOptions
+	options.Temp = 0.5
+	options.TopK = 40
+
+	other := DefaultOptions
+	other.ModelPath = "model.gguf"
+	other.TopP = 0.9
+	other.MaxTokens = 64
+	other.Stop = []string{"###"}
+	other.Seed = 42
+	other.JSONSchema = []byte(`{"type":"string"}`)
+
+	want := DefaultOptions
+	want.ModelPath = "model.gguf"
+	want.Temp = 0.5
+	want.TopK = 40
+	want.TopP = 0.9
+	want.MaxTokens = 64
+	want.Stop = []string{"###"}
+	want.Seed = 42
+	want.JSONSchema = []byte(`{"type":"string"}`)
+
+	options.Update(other)
+	if !reflect.DeepEqual(options, want) {
+		t.Fatalf("Options.Update(%v) = %v, want %v", other, options, want)
+	}
+}
+
+// fakeCompleter answers with the words of the last message.
+type fakeCompleter struct{}
+
+func (fakeCompleter) CompleteText(ctx context.Context, p Prompt) (Completion, error) {
+	return Completion{Text: p.Messages[len(p.Messages)-1].Content, Result: Result{StopReason: StopEOS}}, nil
+}
+
+func (fakeCompleter) CompleteStream(ctx context.Context, p Prompt) (*Stream, error) {
+	words := strings.SplitAfter(p.Messages[len(p.Messages)-1].Content, " ")
+	return NewTextStream(words, Result{StopReason: StopEOS}), nil
+}
+
+func (fakeCompleter) Tokenize(ctx context.Context, text string) ([]int, error) {
+	return []int{len(text)}, nil
+}
+
+func (fakeCompleter) Embed(ctx context.Context, text string) ([]float32, error) {
+	return []float32{float32(len(text))}, nil
+}
+
+func TestSetCompleter(t *testing.T) {
+	SetCompleter(fakeCompleter{})
+	t.Cleanup(func() { SetCompleter(nil) })
+
+	prompt := Prompt{}
+	prompt.Add("Once upon a time")
+	c, err := Complete(context.TODO(), prompt)
+	if err != nil {
+		t.Fatalf("Complete() returns error: %v", err)
+	}
+	var got []string
+	for token := range c {
+		got = append(got, token)
+	}
+	if want := []string{"Once ", "upon ", "a ", "time"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Complete() = %q, want %q", got, want)
+	}
+
+	tokens, err := Tokenize(context.TODO(), "abc")
+	if err != nil || !reflect.DeepEqual(tokens, []int{3}) {
+		t.Fatalf("Tokenize() = %v, %v, want %v", tokens, err, []int{3})
+	}
+}
@@ -5,18 +5,37 @@ import (
 	"bytes"
 	"log/slog"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
 )
 
-// CmdLogger is a slog.Logger wrapper for exec.Cmd output.
+// CmdLogger is a slog.Logger wrapper for exec.Cmd output. It keeps the last
+// lines of output for error reporting.
 type CmdLogger struct {
 	// Log specifies an optional logger for exec.Cmd output
 	// If nil, logging is done via the slog package's standard logger.
 	Log *slog.Logger
+
+	// TailSize specifies the number of last lines returned by Tail.
+	// If zero, 10 lines are kept.
+	TailSize int
+
+	mu   sync.Mutex
+	tail []string
+}
+
+// Tail returns the last lines of output.
+func (c *CmdLogger) Tail() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.tail)
 }
 
 // Write implements io.Writer.
 func (c *CmdLogger) Write(p []byte) (n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.Log == nil {
 		c.Log = slog.Default()
 	}
@@ -32,6 +51,11 @@ func (c *CmdLogger) Write(p []byte) (n int, err error) {
 			continue
 		}
 
+		c.tail = append(c.tail, line)
+		if size := c.tailSize(); len(c.tail) > size {
+			c.tail = c.tail[len(c.tail)-size:]
+		}
+
 		if strings.Contains(line, `"level":"ERROR"`) {
 			c.Log.Error(line)
 		} else if strings.Contains(line, `"level":"WARNING"`) {
@@ -43,3 +67,10 @@ func (c *CmdLogger) Write(p []byte) (n int, err error) {
 	}
 	return len(p), nil
 }
+
+func (c *CmdLogger) tailSize() int {
+	if c.TailSize == 0 {
+		return 10
+	}
+	return c.TailSize
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"io"
 	"log/slog"
+	"slices"
 	"testing"
 )
 
@@ -47,3 +48,15 @@ func TestCmdLogger(t *testing.T) {
 		})
 	}
 }
+
+func TestCmdLoggerTail(t *testing.T) {
+	logger := CmdLogger{Log: slog.New(slog.NewTextHandler(io.Discard, nil)), TailSize: 2}
+	logger.Write([]byte("loading model\n....\n"))
+	logger.Write([]byte("error: out of memory\n"))
+	logger.Write([]byte("exiting\n"))
+
+	want := []string{"error: out of memory", "exiting"}
+	if got := logger.Tail(); !slices.Equal(got, want) {
+		t.Fatalf("CmdLogger.Tail() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,258 @@
+package llama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// OllamaClient represents client for the Ollama server.
+//
+// If the prompt format is empty or auto, messages are sent to the chat
+// endpoint and the server applies the template of the model. Otherwise, the
+// prompt is rendered in its format and sent to the generate endpoint in raw
+// mode.
+//
+// See: https://github.com/ollama/ollama/blob/main/docs/api.md
+type OllamaClient struct {
+	// Addr specifies the base URL of the server.
+	// If empty, $OLLAMA_HOST or "http://localhost:11434" is used.
+	Addr string
+
+	// Model specifies the name of the model pulled into Ollama (e.g. "mistral").
+	Model string
+
+	// APIKey specifies an optional key sent as a bearer token (e.g. to
+	// a reverse proxy).
+	APIKey string
+
+	// Options specifies sampling options. Grammar is not supported by the
+	// API and is ignored.
+	// If nil, DefaultOptions are used.
+	Options *Options
+
+	// HTTPClient specifies the HTTP client used for requests.
+	// If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Logger specifies logger for the client.
+	Logger *slog.Logger
+}
+
+var _ Completer = (*OllamaClient)(nil)
+
+// ollamaOptions represents model parameters of the Ollama request.
+type ollamaOptions struct {
+	Temp             float32  `json:"temperature"`
+	TopK             int      `json:"top_k"`
+	TopP             float32  `json:"top_p"`
+	MinP             float32  `json:"min_p"`
+	TypicalP         float32  `json:"typical_p"`
+	RepeatPenalty    float32  `json:"repeat_penalty"`
+	RepeatLastN      int      `json:"repeat_last_n"`
+	PresencePenalty  float32  `json:"presence_penalty"`
+	FrequencyPenalty float32  `json:"frequency_penalty"`
+	Mirostat         int      `json:"mirostat"`
+	MirostatTau      float32  `json:"mirostat_tau"`
+	MirostatEta      float32  `json:"mirostat_eta"`
+	PredictNum       int      `json:"num_predict"`
+	Stop             []string `json:"stop,omitempty"`
+	Seed             uint     `json:"seed,omitempty"`
+}
+
+// ollamaRequest represents a request of the chat and generate endpoints.
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []chatMessage   `json:"messages,omitempty"`
+	Prompt   string          `json:"prompt,omitempty"`
+	Raw      bool            `json:"raw,omitempty"`
+	Stream   bool            `json:"stream"`
+	Format   json.RawMessage `json:"format,omitempty"`
+	Options  ollamaOptions   `json:"options"`
+}
+
+// ollamaEvent represents a line of the streamed Ollama response.
+type ollamaEvent struct {
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	Response        string `json:"response"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+// client returns the Client for the same server, which is used for common
+// HTTP handling.
+func (c *OllamaClient) client() *Client {
+	addr := c.Addr
+	if addr == "" {
+		addr = ollamaHost(os.Getenv("OLLAMA_HOST"))
+	}
+	return &Client{Addr: addr, APIKey: c.APIKey, HTTPClient: c.HTTPClient, Logger: c.Logger}
+}
+
+// ollamaHost returns the address of the Ollama server from the value of
+// OLLAMA_HOST. Like in Ollama, the address without a scheme uses HTTP and
+// the default port 11434.
+func ollamaHost(env string) string {
+	const defaultPort = "11434"
+	if env == "" {
+		return "http://localhost:" + defaultPort
+	}
+	if strings.Contains(env, "://") {
+		return env
+	}
+	host, path, _ := strings.Cut(env, "/")
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), defaultPort)
+	}
+	if path != "" {
+		host += "/" + path
+	}
+	return "http://" + host
+}
+
+// Complete returns a channel with completion results for given string. The
+// channel is closed at the end of the answer or on error. Use CompleteStream
+// to distinguish between them.
+func (c *OllamaClient) Complete(ctx context.Context, p Prompt) (chan string, error) {
+	return complete(ctx, c, p)
+}
+
+// CompleteStream returns a Stream with completion results for given string.
+// The caller must close the stream.
+func (c *OllamaClient) CompleteStream(ctx context.Context, p Prompt) (*Stream, error) {
+	endpoint, req, err := c.request(p)
+	if err != nil {
+		return nil, fmt.Errorf("could not complete: %w", err)
+	}
+	resp, err := c.client().send(ctx, http.MethodPost, endpoint, req)
+	if err != nil {
+		return nil, fmt.Errorf("could not complete: %w", err)
+	}
+	return newStream(resp.Body, decodeOllamaEvents(resp.Body), req.Options.Stop), nil
+}
+
+// CompleteText returns the whole answer for given string. It blocks until
+// the answer is generated or the context is cancelled.
+func (c *OllamaClient) CompleteText(ctx context.Context, p Prompt) (Completion, error) {
+	stream, err := c.CompleteStream(ctx, p)
+	if err != nil {
+		return Completion{}, err
+	}
+	return collect(ctx, stream)
+}
+
+// Tokenize is not supported by the Ollama API.
+func (c *OllamaClient) Tokenize(ctx context.Context, text string) ([]int, error) {
+	return nil, errors.New("could not tokenize: not supported by Ollama API")
+}
+
+// Embed returns the embedding vector of the text.
+func (c *OllamaClient) Embed(ctx context.Context, text string) ([]float32, error) {
+	var resp struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	req := map[string]string{"model": c.Model, "input": text}
+	if err := c.client().call(ctx, http.MethodPost, "/api/embed", req, &resp); err != nil {
+		return nil, fmt.Errorf("could not embed: %w", err)
+	}
+	if len(resp.Embeddings) == 0 {
+		return nil, errors.New("could not embed: server returned no embeddings")
+	}
+	return resp.Embeddings[0], nil
+}
+
+// request returns the endpoint and the completion request for the prompt.
+func (c *OllamaClient) request(p Prompt) (string, ollamaRequest, error) {
+	options := optionsOrDefault(c.Options)
+	req := ollamaRequest{
+		Model:  c.Model,
+		Stream: true,
+		Format: options.JSONSchema,
+		Options: ollamaOptions{
+			Temp:             options.Temp,
+			TopK:             options.TopK,
+			TopP:             options.TopP,
+			MinP:             options.MinP,
+			TypicalP:         options.TypicalP,
+			RepeatPenalty:    options.RepeatPenalty,
+			RepeatLastN:      options.RepeatLastN,
+			PresencePenalty:  options.PresencePenalty,
+			FrequencyPenalty: options.FrequencyPenalty,
+			Mirostat:         options.Mirostat,
+			MirostatTau:      options.MirostatTau,
+			MirostatEta:      options.MirostatEta,
+			PredictNum:       options.MaxTokens,
+			Stop:             stopStrings(options.Stop),
+			Seed:             options.Seed,
+		},
+	}
+
+	if p.Format == "" || strings.EqualFold(p.Format, FormatAuto) {
+		if p.System != "" {
+			req.Messages = append(req.Messages, chatMessage{Role: RoleSystem, Content: p.System})
+		}
+		for _, m := range p.Messages {
+			req.Messages = append(req.Messages, chatMessage{Role: m.Role, Content: m.Content})
+		}
+		return "/api/chat", req, nil
+	}
+
+	prompt, err := p.Render()
+	if err != nil {
+		return "", ollamaRequest{}, err
+	}
+	req.Prompt = prompt
+	req.Raw = true
+	req.Options.Stop = stopStrings(options.Stop, p.Stop())
+	return "/api/generate", req, nil
+}
+
+// decodeOllamaEvents returns a function which decodes parts of the answer
+// from newline-delimited JSON objects of the chat and generate endpoints.
+func decodeOllamaEvents(r io.Reader) func() (chunk, error) {
+	scanner := newLineScanner(r)
+	return func() (chunk, error) {
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+
+			var event ollamaEvent
+			if err := json.Unmarshal(line, &event); err != nil {
+				return chunk{}, fmt.Errorf("cannot decode server response: %w", err)
+			}
+			if event.Error != "" {
+				return chunk{}, fmt.Errorf("LLM server returned error: %s", event.Error)
+			}
+			if event.Done {
+				result := Result{
+					TokensEvaluated: event.PromptEvalCount,
+					TokensPredicted: event.EvalCount,
+				}
+				switch event.DoneReason {
+				case "stop":
+					result.StopReason = StopEOS
+				case "length":
+					result.StopReason = StopLimit
+				}
+				return chunk{content: event.Message.Content + event.Response, final: &result}, nil
+			}
+			return chunk{content: event.Message.Content + event.Response}, nil
+		}
+		return chunk{}, scanErr(scanner)
+	}
+}
@@ -0,0 +1,122 @@
+package llama
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOllamaClientCompleteText(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaRequest
+		if json.NewDecoder(r.Body).Decode(&req) != nil || req.Model != "mistral" || !req.Stream {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		switch r.URL.Path {
+		case "/api/chat":
+			want := []chatMessage{{RoleSystem, "Be brief."}, {RoleUser, "How are you?"}}
+			if !reflect.DeepEqual(req.Messages, want) || req.Options.Temp != 0.5 || req.Options.MinP != 0.05 {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "{\"error\":\"unexpected request: %+v\"}", req)
+				return
+			}
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Fine"},"done":false}`)
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":", thanks."},"done":false}`)
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":12,"eval_count":3}`)
+		case "/api/generate":
+			if req.Prompt != "<|im_start|>system\nBe brief.<|im_end|>\n<|im_start|>user\nHow are you?<|im_end|>\n<|im_start|>assistant\n" || !req.Raw || !reflect.DeepEqual(req.Options.Stop, []string{"<|im_end|>", "<|im_start|>"}) {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "{\"error\":\"unexpected request: %+v\"}", req)
+				return
+			}
+			fmt.Fprintln(w, `{"response":"Fine, thanks.","done":false}`)
+			fmt.Fprintln(w, `{"response":"","done":true,"done_reason":"length","prompt_eval_count":20,"eval_count":4}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	options := DefaultOptions
+	options.Temp = 0.5
+	options.MinP = 0.05
+	client := OllamaClient{Addr: server.URL, Model: "mistral", Options: &options, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	testcases := []struct {
+		format string
+		want   Completion
+	}{
+		{"", Completion{Text: "Fine, thanks.", Result: Result{TokensEvaluated: 12, TokensPredicted: 3, StopReason: StopEOS}}},
+		{"ChatML", Completion{Text: "Fine, thanks.", Result: Result{TokensEvaluated: 20, TokensPredicted: 4, StopReason: StopLimit}}},
+	}
+	for _, tc := range testcases {
+		prompt := Prompt{Format: tc.format, System: "Be brief."}
+		prompt.Add("How are you?")
+
+		got, err := client.CompleteText(context.TODO(), prompt)
+		if err != nil {
+			t.Fatalf("client.CompleteText(%q) returns error: %v", tc.format, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("client.CompleteText(%q) = %+v, want %+v", tc.format, got, tc.want)
+		}
+	}
+}
+
+func TestOllamaClientCompleteStream_Errors(t *testing.T) {
+	testcases := []struct {
+		name   string
+		status int
+		lines  string
+		want   string
+	}{
+		{"missing model", http.StatusNotFound, `{"error":"model 'mistral' not found"}`, "model 'mistral' not found"},
+		{"error line", http.StatusOK, `{"error":"out of memory"}`, "out of memory"},
+		{"malformed", http.StatusOK, `{"response":`, "cannot decode server response"},
+		{"unexpected end", http.StatusOK, `{"response":"Fi","done":false}`, "unexpected EOF"},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.status)
+				fmt.Fprintln(w, tc.lines)
+			}))
+			defer server.Close()
+
+			client := OllamaClient{Addr: server.URL, Model: "mistral", Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+			_, err := client.CompleteText(context.TODO(), Prompt{})
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("client.CompleteText() = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestOllamaHost(t *testing.T) {
+	testcases := []struct {
+		env  string
+		want string
+	}{
+		{"", "http://localhost:11434"},
+		{"0.0.0.0", "http://0.0.0.0:11434"},
+		{"gpu-box:8000", "http://gpu-box:8000"},
+		{"https://ollama.example.com", "https://ollama.example.com"},
+		{"[::1]/ollama", "http://[::1]:11434/ollama"},
+		{"http://[::1]:11434/ollama", "http://[::1]:11434/ollama"},
+	}
+	for _, tc := range testcases {
+		if got := ollamaHost(tc.env); got != tc.want {
+			t.Errorf("ollamaHost(%q) = %q, want %q", tc.env, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package llama
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// OpenAIClient represents client for servers with OpenAI-compatible chat
+// completions API (e.g. LM Studio, vLLM, llamafile, llama.cpp). The server
+// applies its own chat template, so the prompt format is ignored.
+//
+// See: https://platform.openai.com/docs/api-reference/chat
+type OpenAIClient struct {
+	// Addr specifies the base URL of the server (without "/v1").
+	// If empty, "http://localhost:8080" is used.
+	Addr string
+
+	// Model specifies the name of the model served by the server.
+	// If empty, the server default is used.
+	Model string
+
+	// APIKey specifies an optional key sent as a bearer token.
+	APIKey string
+
+	// Options specifies sampling options. Options which are not supported by
+	// the API (e.g. Mirostat) are ignored.
+	// If nil, DefaultOptions are used.
+	Options *Options
+
+	// HTTPClient specifies the HTTP client used for requests.
+	// If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Logger specifies logger for the client.
+	Logger *slog.Logger
+}
+
+var _ Completer = (*OpenAIClient)(nil)
+
+// chatMessage represents a message of the chat completion request.
+type chatMessage struct {
+	Role    Role   `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatRequest represents a request of the chat completions endpoint.
+type chatRequest struct {
+	Model            string          `json:"model,omitempty"`
+	Messages         []chatMessage   `json:"messages"`
+	Stream           bool            `json:"stream"`
+	StreamOptions    *streamOptions  `json:"stream_options,omitempty"`
+	Temp             float32         `json:"temperature"`
+	TopP             float32         `json:"top_p"`
+	MaxTokens        int             `json:"max_tokens,omitempty"`
+	Stop             []string        `json:"stop,omitempty"`
+	Seed             uint            `json:"seed,omitempty"`
+	PresencePenalty  float32         `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float32         `json:"frequency_penalty,omitempty"`
+	ResponseFormat   *responseFormat `json:"response_format,omitempty"`
+
+	// extensions supported by most of local servers
+	TopK int     `json:"top_k,omitempty"`
+	MinP float32 `json:"min_p,omitempty"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type responseFormat struct {
+	Type       string `json:"type"`
+	JSONSchema struct {
+		Name   string          `json:"name"`
+		Schema json.RawMessage `json:"schema"`
+	} `json:"json_schema"`
+}
+
+// chatEvent represents a server-sent event of the streamed chat completion.
+type chatEvent struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// client returns the Client for the same server, which is used for requests
+// common for both APIs.
+func (c *OpenAIClient) client() *Client {
+	addr := c.Addr
+	if addr == "" {
+		addr = "http://localhost:8080"
+	}
+	return &Client{Addr: addr, APIKey: c.APIKey, HTTPClient: c.HTTPClient, Logger: c.Logger}
+}
+
+// Complete returns a channel with completion results for given string. The
+// channel is closed at the end of the answer or on error. Use CompleteStream
+// to distinguish between them.
+func (c *OpenAIClient) Complete(ctx context.Context, p Prompt) (chan string, error) {
+	return complete(ctx, c, p)
+}
+
+// CompleteStream returns a Stream with completion results for given string.
+// The caller must close the stream.
+func (c *OpenAIClient) CompleteStream(ctx context.Context, p Prompt) (*Stream, error) {
+	resp, err := c.client().send(ctx, http.MethodPost, "/v1/chat/completions", c.request(p))
+	if err != nil {
+		return nil, fmt.Errorf("could not complete: %w", err)
+	}
+	return newStream(resp.Body, decodeChatEvents(resp.Body), optionsOrDefault(c.Options).Stop), nil
+}
+
+// CompleteText returns the whole answer for given string. It blocks until
+// the answer is generated or the context is cancelled.
+func (c *OpenAIClient) CompleteText(ctx context.Context, p Prompt) (Completion, error) {
+	stream, err := c.CompleteStream(ctx, p)
+	if err != nil {
+		return Completion{}, err
+	}
+	return collect(ctx, stream)
+}
+
+// Tokenize is not supported by the chat completions API.
+func (c *OpenAIClient) Tokenize(ctx context.Context, text string) ([]int, error) {
+	return nil, errors.New("could not tokenize: not supported by OpenAI-compatible API")
+}
+
+// Embed returns the embedding vector of the text.
+func (c *OpenAIClient) Embed(ctx context.Context, text string) ([]float32, error) {
+	var resp struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	req := map[string]string{"model": c.Model, "input": text}
+	if err := c.client().call(ctx, http.MethodPost, "/v1/embeddings", req, &resp); err != nil {
+		return nil, fmt.Errorf("could not embed: %w", err)
+	}
+	if len(resp.Data) == 0 {
+		return nil, errors.New("could not embed: server returned no embeddings")
+	}
+	return resp.Data[0].Embedding, nil
+}
+
+// request builds the chat completion request for the prompt.
+func (c *OpenAIClient) request(p Prompt) chatRequest {
+	options := optionsOrDefault(c.Options)
+	var messages []chatMessage
+	if p.System != "" {
+		messages = append(messages, chatMessage{Role: RoleSystem, Content: p.System})
+	}
+	for _, m := range p.Messages {
+		messages = append(messages, chatMessage{Role: m.Role, Content: m.Content})
+	}
+
+	req := chatRequest{
+		Model:            c.Model,
+		Messages:         messages,
+		Stream:           true,
+		StreamOptions:    &streamOptions{IncludeUsage: true},
+		Temp:             options.Temp,
+		TopP:             options.TopP,
+		Stop:             options.Stop,
+		Seed:             options.Seed,
+		PresencePenalty:  options.PresencePenalty,
+		FrequencyPenalty: options.FrequencyPenalty,
+		TopK:             options.TopK,
+		MinP:             options.MinP,
+	}
+	if options.MaxTokens > 0 {
+		req.MaxTokens = options.MaxTokens
+	}
+	if options.JSONSchema != nil {
+		req.ResponseFormat = &responseFormat{Type: "json_schema"}
+		req.ResponseFormat.JSONSchema.Name = "answer"
+		req.ResponseFormat.JSONSchema.Schema = options.JSONSchema
+	}
+	return req
+}
+
+// decodeChatEvents returns a function which decodes parts of the answer from
+// server-sent events of the chat completions endpoint.
+func decodeChatEvents(r io.Reader) func() (chunk, error) {
+	scanner := newLineScanner(r)
+	result := Result{}
+	return func() (chunk, error) {
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(line) == 0 || line[0] == ':' {
+				// events separator or comment
+				continue
+			}
+			line = bytes.TrimPrefix(line, []byte("data: "))
+			if string(line) == "[DONE]" {
+				return chunk{final: &result}, nil
+			}
+
+			var event chatEvent
+			if err := json.Unmarshal(line, &event); err != nil {
+				return chunk{}, fmt.Errorf("cannot decode server event: %w", err)
+			}
+			if event.Error != nil {
+				return chunk{}, fmt.Errorf("LLM server returned error: %s", event.Error.Message)
+			}
+			if event.Usage != nil {
+				result.TokensEvaluated = event.Usage.PromptTokens
+				result.TokensPredicted = event.Usage.CompletionTokens
+			}
+			if len(event.Choices) == 0 {
+				continue
+			}
+			switch event.Choices[0].FinishReason {
+			case "stop":
+				result.StopReason = StopEOS
+			case "length":
+				result.StopReason = StopLimit
+			}
+			return chunk{content: event.Choices[0].Delta.Content}, nil
+		}
+		return chunk{}, scanErr(scanner)
+	}
+}
@@ -0,0 +1,99 @@
+package llama
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIClientComplete(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req chatRequest
+		if r.URL.Path != "/v1/chat/completions" || json.NewDecoder(r.Body).Decode(&req) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		want := []chatMessage{{RoleSystem, "Be brief."}, {RoleUser, "Hi"}, {RoleAssistant, "Hello"}, {RoleUser, "How are you?"}}
+		if req.Model != "tiny" || !req.Stream || !reflect.DeepEqual(req.Messages, want) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "unexpected request: %+v", req)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"},\"finish_reason\":null}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Fine\"},\"finish_reason\":null}]}\n\n")
+		fmt.Fprint(w, ": keep-alive\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\", thanks.\"},\"finish_reason\":\"stop\"}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":3}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	client := OpenAIClient{Addr: server.URL, Model: "tiny", Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	prompt := Prompt{System: "Be brief."}
+	prompt.Add("Hi")
+	prompt.AddAssistant("Hello")
+	prompt.Add("How are you?")
+
+	c, err := client.Complete(context.TODO(), prompt)
+	if err != nil {
+		t.Fatalf("client.Complete() returns error: %v", err)
+	}
+	var got []string
+	for token := range c {
+		got = append(got, token)
+	}
+	if want := []string{"Fine", ", thanks."}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("client.Complete() = %q, want %q", got, want)
+	}
+
+	completion, err := client.CompleteText(context.TODO(), prompt)
+	if err != nil {
+		t.Fatalf("client.CompleteText() returns error: %v", err)
+	}
+	want := Completion{Text: "Fine, thanks.", Result: Result{TokensEvaluated: 12, TokensPredicted: 3, StopReason: StopEOS}}
+	if !reflect.DeepEqual(completion, want) {
+		t.Fatalf("client.CompleteText() = %+v, want %+v", completion, want)
+	}
+}
+
+func TestOpenAIClientCompleteStream_Errors(t *testing.T) {
+	testcases := []struct {
+		name   string
+		events string
+		want   string
+	}{
+		{"error event", "data: {\"error\":{\"message\":\"model not loaded\"}}\n\n", "model not loaded"},
+		{"malformed", "data: {\"choices\":\n\n", "cannot decode server event"},
+		{"unexpected end", "data: {\"choices\":[{\"delta\":{\"content\":\"Fi\"}}]}\n\n", "unexpected EOF"},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tc.events)
+			}))
+			defer server.Close()
+
+			client := OpenAIClient{Addr: server.URL, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+			stream, err := client.CompleteStream(context.TODO(), Prompt{})
+			if err != nil {
+				t.Fatalf("client.CompleteStream() returns error: %v", err)
+			}
+			defer stream.Close()
+			for stream.Next() {
+			}
+			if err := stream.Err(); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("stream.Err() = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
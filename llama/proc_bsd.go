@@ -0,0 +1,11 @@
+//go:build unix && !linux
+
+package llama
+
+import "syscall"
+
+// sysProcAttr returns attributes of the server process. The server is placed
+// in its own process group.
+func sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
@@ -0,0 +1,14 @@
+//go:build linux
+
+package llama
+
+import "syscall"
+
+// sysProcAttr returns attributes of the server process. The server is placed
+// in its own process group and killed when boludo dies (even by SIGKILL).
+//
+// Pdeathsig is sent when the thread which started the process exits, but Go
+// runtime does not terminate threads of goroutines which are not locked.
+func sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+}
@@ -0,0 +1,24 @@
+//go:build !unix
+
+package llama
+
+import (
+	"os"
+	"syscall"
+)
+
+// sysProcAttr returns attributes of the server process.
+func sysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// interrupt asks the server process to exit. Interrupts are not supported
+// on this platform, so the process is killed.
+func interrupt(p *os.Process) error {
+	return p.Kill()
+}
+
+// kill terminates the server process immediately.
+func kill(p *os.Process) error {
+	return p.Kill()
+}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package llama
+
+import (
+	"os"
+	"syscall"
+)
+
+// interrupt asks the server process (and its process group) to exit.
+func interrupt(p *os.Process) error {
+	return signalGroup(p, syscall.SIGINT)
+}
+
+// kill terminates the server process (and its process group) immediately.
+func kill(p *os.Process) error {
+	return signalGroup(p, syscall.SIGKILL)
+}
+
+func signalGroup(p *os.Process, sig syscall.Signal) error {
+	if err := syscall.Kill(-p.Pid, sig); err == nil {
+		return nil
+	}
+	// process is not a leader of its group
+	return p.Signal(sig)
+}
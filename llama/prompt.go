@@ -2,90 +2,254 @@ package llama
 
 import (
 	"fmt"
+	"slices"
 	"strings"
+	"sync"
+	"text/template"
 )
 
-// supported prompt formats
-var promptFormats = map[string]func(Prompt) string{
-	"": func(p Prompt) string {
-		return fmt.Sprintf("%s\n%s", p.System, strings.Join(p.userPrompt, "\n"))
+// built-in prompt formats
+var builtinFormats = map[string]PromptFormat{
+	"": {
+		// plain text is completed as is, but conversations (with assistant
+		// turns) need role markers and stop strings (see: Prompt.Stop)
+		Template: "{{.System}}\n" +
+			"{{$chat := false}}{{range .Messages}}{{if eq .Role \"assistant\"}}{{$chat = true}}{{end}}{{end}}" +
+			"{{range $i, $m := .Messages}}{{if $i}}\n{{end}}" +
+			"{{if $chat}}{{if eq $m.Role \"user\"}}User: {{else if eq $m.Role \"assistant\"}}Assistant: {{end}}{{end}}" +
+			"{{$m.Content}}{{end}}" +
+			"{{if and $chat .AddGenerationPrompt}}\nAssistant:{{end}}",
+		Stop: []string{"\nUser:"},
 	},
-	"alpaca": func(p Prompt) string {
-		systemPrompt := ""
-		if p.System != "" {
-			systemPrompt = fmt.Sprintf("%s\n\n", p.System)
-		}
+	"alpaca": {
+		Template: "{{if .System}}{{.System}}\n\n{{end}}" +
+			"{{range .Messages}}" +
+			"{{if eq .Role \"system\"}}{{.Content}}\n\n" +
+			"{{else if eq .Role \"assistant\"}}### Response:\n{{.Content}}\n\n" +
+			"{{else}}### Instruction:\n{{.Content}}\n\n{{end}}" +
+			"{{else}}### Instruction:\n\n{{end}}" +
+			"{{if .AddGenerationPrompt}}### Response:\n{{end}}",
+		Stop: []string{"### Instruction:"},
+	},
+	"chatml": {
+		Template: "<|im_start|>system\n{{.System}}<|im_end|>\n" +
+			"{{range .Messages}}<|im_start|>{{.Role}}\n{{.Content}}<|im_end|>\n" +
+			"{{else}}<|im_start|>user\n<|im_end|>\n{{end}}" +
+			"{{if .AddGenerationPrompt}}<|im_start|>assistant\n{{end}}",
+		Stop: []string{"<|im_end|>", "<|im_start|>"},
+	},
+	"gemma": {
+		// system prompt is not supported, so it is added to the first turn
+		Template: "{{range $i, $m := .Messages}}" +
+			"<start_of_turn>{{if eq $m.Role \"assistant\"}}model{{else}}user{{end}}\n" +
+			"{{if and (eq $i 0) $.System}}{{$.System}}\n\n{{end}}{{$m.Content}}<end_of_turn>\n" +
+			"{{else}}<start_of_turn>user\n{{.System}}<end_of_turn>\n{{end}}" +
+			"{{if .AddGenerationPrompt}}<start_of_turn>model\n{{end}}",
+		Stop: []string{"<end_of_turn>", "<start_of_turn>"},
+	},
+	"llama3": {
+		Template: "{{if .System}}<|start_header_id|>system<|end_header_id|>\n\n{{.System}}<|eot_id|>{{end}}" +
+			"{{range .Messages}}<|start_header_id|>{{.Role}}<|end_header_id|>\n\n{{.Content}}<|eot_id|>{{end}}" +
+			"{{if .AddGenerationPrompt}}<|start_header_id|>assistant<|end_header_id|>\n\n{{end}}",
+		Stop: []string{"<|eot_id|>", "<|start_header_id|>"},
+	},
+	"mistral": {
+		// system prompt is not supported, so it is added to the first turn
+		Template: "{{range $i, $m := .Messages}}" +
+			"{{if eq $m.Role \"assistant\"}}{{$m.Content}}</s>" +
+			"{{else if eq $m.Role \"system\"}}{{$m.Content}}\n\n" +
+			"{{else}}[INST] {{if and (eq $i 0) $.System}}{{$.System}}\n\n{{end}}{{$m.Content}} [/INST]{{end}}" +
+			"{{else}}[INST] {{.System}} [/INST]{{end}}",
+		Stop: []string{"</s>", "[INST]"},
+	},
+	"openchat": {
+		Template: "{{if .System}}{{.System}}<|end_of_turn|>{{end}}" +
+			"{{range .Messages}}" +
+			"{{if eq .Role \"system\"}}{{.Content}}<|end_of_turn|>" +
+			"{{else if eq .Role \"assistant\"}}GPT4 Correct Assistant: {{.Content}}<|end_of_turn|>" +
+			"{{else}}GPT4 Correct User: {{.Content}}<|end_of_turn|>{{end}}" +
+			"{{else}}GPT4 Correct User: <|end_of_turn|>{{end}}" +
+			"{{if .AddGenerationPrompt}}GPT4 Correct Assistant: {{end}}",
+		Stop: []string{"<|end_of_turn|>", "GPT4 Correct User:"},
+	},
+	"zephyr": {
+		Template: "<|system|>\n{{.System}}</s>\n" +
+			"{{range .Messages}}<|{{.Role}}|>\n{{.Content}}</s>\n" +
+			"{{else}}<|user|>\n</s>\n{{end}}" +
+			"{{if .AddGenerationPrompt}}<|assistant|>\n{{end}}",
+		Stop: []string{"</s>", "<|user|>"},
+	},
+}
 
-		userPrompt := ""
-		for i := range p.userPrompt {
-			userPrompt += fmt.Sprintf("### Instruction:\n%s\n\n", p.userPrompt[i])
-		}
-		if userPrompt == "" {
-			userPrompt = "### Instruction:\n\n"
-		}
+// FormatAuto is a name of prompt format which should be detected from the chat
+// template stored in the model file (see: DetectFormat).
+const FormatAuto = "auto"
 
-		return fmt.Sprintf("%s%s### Response:\n", systemPrompt, userPrompt)
-	},
-	"chatml": func(p Prompt) string {
-		systemPrompt := fmt.Sprintf("<|im_start|>system\n%s<|im_end|>\n", p.System)
-		userPrompt := ""
-		for i := range p.userPrompt {
-			userPrompt += fmt.Sprintf("<|im_start|>user\n%s<|im_end|>\n", p.userPrompt[i])
-		}
-		if userPrompt == "" {
-			userPrompt = "<|im_start|>user\n<|im_end|>\n"
-		}
+// registered prompt formats (keys are lowercase)
+var (
+	promptFormatsMu sync.RWMutex
+	promptFormats   = map[string]PromptFormat{}
+)
 
-		return fmt.Sprintf("%s%s<|im_start|>assistant\n", systemPrompt, userPrompt)
-	},
-	"openchat": func(p Prompt) string {
-		systemPrompt := p.System
-		if systemPrompt != "" {
-			systemPrompt += "<|end_of_turn|>"
-		}
-		userPrompt := ""
-		for i := range p.userPrompt {
-			userPrompt += fmt.Sprintf("GPT4 Correct User: %s<|end_of_turn|>", p.userPrompt[i])
-		}
-		if userPrompt == "" {
-			userPrompt = "GPT4 Correct User: <|end_of_turn|>"
+func init() {
+	for name, format := range builtinFormats {
+		if err := RegisterFormat(name, format); err != nil {
+			panic(err)
 		}
+	}
+}
 
-		return fmt.Sprintf("%s%sGPT4 Correct Assistant: ", systemPrompt, userPrompt)
-	},
-	"zephyr": func(p Prompt) string {
-		systemPrompt := fmt.Sprintf("<|system|>\n%s</s>\n", p.System)
-		userPrompt := ""
-		for i := range p.userPrompt {
-			userPrompt += fmt.Sprintf("<|user|>\n%s</s>\n", p.userPrompt[i])
-		}
-		if userPrompt == "" {
-			userPrompt = "<|user|>\n</s>\n"
+// PromptFormat represents a template for rendering prompts of specific model
+// family.
+type PromptFormat struct {
+	// Template specifies a text/template with the following variables:
+	//   - .System - system prompt
+	//   - .Messages - list of messages (with .Role and .Content)
+	//   - .AddGenerationPrompt - true if template should end with the
+	//     beginning of the assistant answer.
+	Template string
+
+	// Stop specifies strings which end the assistant turn (e.g. the
+	// beginning of the next user turn).
+	Stop []string
+
+	tmpl *template.Template
+}
+
+// promptData represents variables available in prompt templates.
+type promptData struct {
+	System              string
+	Messages            []Message
+	AddGenerationPrompt bool
+}
+
+// RegisterFormat makes prompt format available by name (case-insensitive).
+// It replaces previously registered format with the same name.
+func RegisterFormat(name string, format PromptFormat) error {
+	name = strings.ToLower(name)
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(format.Template)
+	if err != nil {
+		return fmt.Errorf("invalid template of prompt format '%s': %w", name, err)
+	}
+	format.tmpl = tmpl
+
+	promptFormatsMu.Lock()
+	defer promptFormatsMu.Unlock()
+	promptFormats[name] = format
+	return nil
+}
+
+// Formats returns names of registered prompt formats in alphabetical order.
+func Formats() []string {
+	promptFormatsMu.RLock()
+	defer promptFormatsMu.RUnlock()
+
+	names := make([]string, 0, len(promptFormats))
+	for name := range promptFormats {
+		if name != "" {
+			names = append(names, name)
 		}
+	}
+	slices.Sort(names)
+	return names
+}
 
-		return fmt.Sprintf("%s%s<|assistant|>\n", systemPrompt, userPrompt)
-	},
+// lookupFormat returns registered prompt format with given name.
+func lookupFormat(name string) (PromptFormat, bool) {
+	promptFormatsMu.RLock()
+	defer promptFormatsMu.RUnlock()
+
+	format, ok := promptFormats[strings.ToLower(name)]
+	return format, ok
+}
+
+// Role represents the author of a message in the conversation.
+type Role string
+
+// Roles of messages supported by prompt formats.
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+// Message represents a single turn of the conversation.
+type Message struct {
+	Role    Role
+	Content string
 }
 
 // Prompt represents prompt for the LLM.
 type Prompt struct {
-	Format     string
-	System     string
-	userPrompt []string
+	Format string
+	System string
+
+	// Messages specifies the conversation in chronological order. Assistant
+	// messages can be used for few-shot examples or previous answers.
+	Messages []Message
 }
 
-// String returns prompt string in format specified by Format.
+// Render returns prompt string in format specified by Format.
 // If Format is not specified, returns prompt in default format.
-func (p *Prompt) String() string {
-	formatFunc, ok := promptFormats[strings.ToLower(p.Format)]
+// If Format is not registered, returns error.
+func (p *Prompt) Render() (string, error) {
+	if strings.EqualFold(p.Format, FormatAuto) {
+		return "", fmt.Errorf("prompt format '%s' must be resolved with the model file (see: DetectFormat)", p.Format)
+	}
+	format, ok := lookupFormat(p.Format)
 	if !ok {
-		formatFunc = promptFormats[""]
+		return "", fmt.Errorf("unknown prompt format '%s' (available: %s)", p.Format, strings.Join(Formats(), ", "))
 	}
 
-	return formatFunc(*p)
+	s := strings.Builder{}
+	data := promptData{
+		System:              p.System,
+		Messages:            p.Messages,
+		AddGenerationPrompt: true,
+	}
+	if err := format.tmpl.Execute(&s, data); err != nil {
+		return "", fmt.Errorf("cannot render prompt in format '%s': %w", p.Format, err)
+	}
+
+	return s.String(), nil
+}
+
+// Stop returns stop strings of the prompt format. In the default format,
+// they are used only for conversations, so plain text is completed as is.
+func (p *Prompt) Stop() []string {
+	if p.Format == "" && !slices.ContainsFunc(p.Messages, func(m Message) bool { return m.Role == RoleAssistant }) {
+		return nil
+	}
+	format, _ := lookupFormat(p.Format)
+	return format.Stop
+}
+
+// String returns prompt string in format specified by Format.
+// If Format is unknown, returns prompt in default format.
+//
+// Deprecated: Use Render, which reports unknown formats.
+func (p *Prompt) String() string {
+	if s, err := p.Render(); err == nil {
+		return s
+	}
+	fallback := *p
+	fallback.Format = ""
+	s, _ := fallback.Render()
+	return s
 }
 
 // Add adds user prompt to the prompt.
 func (p *Prompt) Add(userPrompt string) {
-	p.userPrompt = append(p.userPrompt, userPrompt)
+	p.AddMessage(RoleUser, userPrompt)
+}
+
+// AddAssistant adds assistant answer to the prompt, so the next completion
+// continues the conversation.
+func (p *Prompt) AddAssistant(answer string) {
+	p.AddMessage(RoleAssistant, answer)
+}
+
+// AddMessage adds a message with given role to the prompt.
+func (p *Prompt) AddMessage(role Role, content string) {
+	p.Messages = append(p.Messages, Message{Role: role, Content: content})
 }
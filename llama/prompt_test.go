@@ -1,6 +1,7 @@
 package llama
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -63,3 +64,130 @@ func TestPromptAdd(t *testing.T) {
 		})
 	}
 }
+
+func TestPromptAddAssistant(t *testing.T) {
+	testcases := []struct {
+		format string
+		want   string
+	}{
+		{"", "\nUser: Hi\nAssistant: Hello!\nUser: How are you?\nAssistant:"},
+		{"Alpaca", "### Instruction:\nHi\n\n### Response:\nHello!\n\n### Instruction:\nHow are you?\n\n### Response:\n"},
+		{"ChatML", "<|im_start|>system\n<|im_end|>\n<|im_start|>user\nHi<|im_end|>\n<|im_start|>assistant\nHello!<|im_end|>\n<|im_start|>user\nHow are you?<|im_end|>\n<|im_start|>assistant\n"},
+		{"OpenChat", "GPT4 Correct User: Hi<|end_of_turn|>GPT4 Correct Assistant: Hello!<|end_of_turn|>GPT4 Correct User: How are you?<|end_of_turn|>GPT4 Correct Assistant: "},
+		{"Zephyr", "<|system|>\n</s>\n<|user|>\nHi</s>\n<|assistant|>\nHello!</s>\n<|user|>\nHow are you?</s>\n<|assistant|>\n"},
+		{"Llama3", "<|start_header_id|>user<|end_header_id|>\n\nHi<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\nHello!<|eot_id|><|start_header_id|>user<|end_header_id|>\n\nHow are you?<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\n"},
+		{"Mistral", "[INST] Hi [/INST]Hello!</s>[INST] How are you? [/INST]"},
+		{"Gemma", "<start_of_turn>user\nHi<end_of_turn>\n<start_of_turn>model\nHello!<end_of_turn>\n<start_of_turn>user\nHow are you?<end_of_turn>\n<start_of_turn>model\n"},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.format, func(t *testing.T) {
+			t.Parallel()
+			prompt := Prompt{Format: tc.format}
+			prompt.Add("Hi")
+			prompt.AddAssistant("Hello!")
+			prompt.Add("How are you?")
+			got := prompt.String()
+			if got != tc.want {
+				t.Fatalf("Prompt{Format: %v}.String() = %q, want %q", tc.format, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPromptStop(t *testing.T) {
+	plain := Prompt{}
+	plain.Add("Once upon a time")
+	chat := plain
+	chat.AddAssistant("there was a dragon.")
+	chatML := Prompt{Format: "ChatML"}
+	testcases := []struct {
+		name   string
+		prompt Prompt
+		want   []string
+	}{
+		{"plain", plain, nil},
+		{"conversation", chat, []string{"\nUser:"}},
+		{"ChatML", chatML, []string{"<|im_end|>", "<|im_start|>"}},
+	}
+	for _, tc := range testcases {
+		if got := tc.prompt.Stop(); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Prompt.Stop() for %s = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPromptAddMessage(t *testing.T) {
+	testcases := []struct {
+		format string
+		want   string
+	}{
+		{"", "\nAnswer briefly.\nUser: 2+2\nAssistant: 4\nUser: 3+3\nAssistant:"},
+		{"Alpaca", "Answer briefly.\n\n### Instruction:\n2+2\n\n### Response:\n4\n\n### Instruction:\n3+3\n\n### Response:\n"},
+		{"ChatML", "<|im_start|>system\n<|im_end|>\n<|im_start|>system\nAnswer briefly.<|im_end|>\n<|im_start|>user\n2+2<|im_end|>\n<|im_start|>assistant\n4<|im_end|>\n<|im_start|>user\n3+3<|im_end|>\n<|im_start|>assistant\n"},
+		{"OpenChat", "Answer briefly.<|end_of_turn|>GPT4 Correct User: 2+2<|end_of_turn|>GPT4 Correct Assistant: 4<|end_of_turn|>GPT4 Correct User: 3+3<|end_of_turn|>GPT4 Correct Assistant: "},
+		{"Zephyr", "<|system|>\n</s>\n<|system|>\nAnswer briefly.</s>\n<|user|>\n2+2</s>\n<|assistant|>\n4</s>\n<|user|>\n3+3</s>\n<|assistant|>\n"},
+		{"Llama3", "<|start_header_id|>system<|end_header_id|>\n\nAnswer briefly.<|eot_id|><|start_header_id|>user<|end_header_id|>\n\n2+2<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\n4<|eot_id|><|start_header_id|>user<|end_header_id|>\n\n3+3<|eot_id|><|start_header_id|>assistant<|end_header_id|>\n\n"},
+		{"Mistral", "Answer briefly.\n\n[INST] 2+2 [/INST]4</s>[INST] 3+3 [/INST]"},
+		{"Gemma", "<start_of_turn>user\nAnswer briefly.<end_of_turn>\n<start_of_turn>user\n2+2<end_of_turn>\n<start_of_turn>model\n4<end_of_turn>\n<start_of_turn>user\n3+3<end_of_turn>\n<start_of_turn>model\n"},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.format, func(t *testing.T) {
+			t.Parallel()
+			prompt := Prompt{Format: tc.format}
+			prompt.AddMessage(RoleSystem, "Answer briefly.")
+			prompt.AddMessage(RoleUser, "2+2")
+			prompt.AddMessage(RoleAssistant, "4")
+			prompt.Add("3+3")
+			got := prompt.String()
+			if got != tc.want {
+				t.Fatalf("Prompt{Format: %v}.String() = %q, want %q", tc.format, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRegisterFormat(t *testing.T) {
+	format := PromptFormat{Template: "{{if .System}}[SYS]{{.System}}{{end}}{{range .Messages}}[{{.Role}}]{{.Content}}{{end}}{{if .AddGenerationPrompt}}[assistant]{{end}}"}
+	if err := RegisterFormat("Test-Brackets", format); err != nil {
+		t.Fatalf("RegisterFormat() returns error: %v", err)
+	}
+
+	prompt := Prompt{Format: "test-brackets", System: "Be brief."}
+	prompt.Add("Hi")
+	want := "[SYS]Be brief.[user]Hi[assistant]"
+	got, err := prompt.Render()
+	if err != nil {
+		t.Fatalf("Prompt.Render() returns error: %v", err)
+	}
+	if got != want {
+		t.Fatalf("Prompt.Render() = %q, want %q", got, want)
+	}
+}
+
+func TestRegisterFormat_Invalid(t *testing.T) {
+	if err := RegisterFormat("test-invalid", PromptFormat{Template: "{{range .Messages}}"}); err == nil {
+		t.Fatalf("RegisterFormat() does not return error for invalid template")
+	}
+	if _, ok := lookupFormat("test-invalid"); ok {
+		t.Fatalf("RegisterFormat() registers invalid template")
+	}
+}
+
+func TestPromptRender_UnknownFormat(t *testing.T) {
+	for _, format := range []string{"unknown", "auto"} {
+		prompt := Prompt{Format: format}
+		if _, err := prompt.Render(); err == nil {
+			t.Fatalf("Prompt{Format: %q}.Render() does not return error", prompt.Format)
+		}
+		prompt.System = "Be brief."
+		prompt.Add("Hi")
+		want := "Be brief.\nHi"
+		if got := prompt.String(); got != want {
+			t.Fatalf("Prompt{Format: %q}.String() = %q, want %q", prompt.Format, got, want)
+		}
+	}
+}
@@ -2,14 +2,19 @@ package llama
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"path"
-	"runtime"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/macie/boludo"
@@ -18,34 +23,202 @@ import (
 // Server represents LLM server.
 type Server struct {
 	// Path specifies a path to LLM server executable.
-	// If empty, "./llm-server" is used.
+	// If empty, Start looks up llm-server and then llama-server in PATH. Use
+	// FindServer to look them up in other directories first.
 	Path string
 
 	// Addr optionally specifies the TCP address for the server to listen on,
-	// in the form "host:port".
-	// If empty, "localhost:24114"is used.
+	// in the form "host:port". Port 0 means that a free port is selected by
+	// Start (only for the default command), which updates Addr afterwards.
+	// If empty, "localhost:24114" is used.
 	Addr string
 
 	// Cmd specifies a command for underlying LLM server.
-	// If nil, the default command is used: `./llm-server --ctx-size 2048`.
+	// If nil, the default command is used: the executable from Path with
+	// arguments based on Options.
 	Cmd *exec.Cmd
 
+	// Options specifies parameters of the default command.
+	// If nil, zero ServerOptions are used.
+	Options *ServerOptions
+
 	// Logger specifies an optional logger for underlying server errors and
 	// debug messages.
 	// If nil, logging is done to stderr.
 	Logger *slog.Logger
+
+	// Slots specifies the number of completions processed in parallel. Each
+	// slot has its own context (see: ServerOptions.CtxSize).
+	// If less than 1, a single slot is used.
+	Slots int
+
+	// ContBatching enables continuous batching, which processes prompts of
+	// new completions together with generation of the running ones.
+	ContBatching bool
+
+	// StartTimeout limits the time of loading the model by Start.
+	// If zero, 10 minutes is used.
+	StartTimeout time.Duration
+
+	// GracePeriod limits the time between interrupting the server by Close
+	// and killing it.
+	// If zero, 5 seconds is used.
+	GracePeriod time.Duration
+
+	// proc is shared by copies of the Server
+	proc *process
+}
+
+// serverNames contains names of LLM server executable, in order of
+// preference.
+var serverNames = []string{"llm-server", "llama-server"}
+
+// FindServer returns a path to LLM server executable (llm-server or
+// llama-server) found in the directories or, if it is not there, in PATH.
+// The error lists all locations which were tried.
+func FindServer(dirs ...string) (string, error) {
+	var tried []string
+	for _, dir := range dirs {
+		for _, name := range serverNames {
+			candidate := filepath.Join(dir, name)
+			if serverPath, err := exec.LookPath(candidate); err == nil {
+				return serverPath, nil
+			}
+			tried = append(tried, candidate)
+		}
+	}
+	for _, name := range serverNames {
+		if serverPath, err := exec.LookPath(name); err == nil {
+			return serverPath, nil
+		}
+	}
+	tried = append(tried, fmt.Sprintf("%s in $PATH", strings.Join(serverNames, ", ")))
+	return "", fmt.Errorf("cannot find LLM server executable, tried: %s", strings.Join(tried, "; "))
+}
+
+// ServerOptions represent parameters for loading the model by LLM server.
+//
+// See: https://github.com/ggerganov/llama.cpp/blob/master/examples/server/README.md
+type ServerOptions struct {
+	// CtxSize specifies the size of the prompt context of a single slot.
+	// If zero, the context length used during model training is used, but
+	// at most 8192 tokens (memory of a longer context is rarely available).
+	CtxSize int
+
+	// Threads specifies the number of threads used during generation.
+	// If zero, the number of physical CPU cores is used.
+	Threads int
+
+	// BatchSize specifies the maximum number of tokens processed at once.
+	// If zero, the server default is used.
+	BatchSize int
+
+	// MLock forces the system to keep the model in RAM.
+	MLock bool
+
+	// NoMMap disables memory-mapping of the model file.
+	NoMMap bool
+
+	// RopeScaling specifies the RoPE frequency scaling method: "none",
+	// "linear" or "yarn".
+	// If empty, the method used during model training is used.
+	RopeScaling string
+
+	// RopeScale specifies the RoPE context scaling factor.
+	// If zero, the model default is used.
+	RopeScale float32
+
+	// ExtraArgs specifies additional command line arguments of the server.
+	ExtraArgs []string
 }
 
+// defaultCtxSize is the context size used when the model file does not
+// contain its context length.
+const defaultCtxSize = 2048
+
+// maxDefaultCtxSize limits the context size of a slot taken from the model
+// file. Memory of the context grows with its size, and recent models are
+// trained with contexts which do not fit into memory of most machines.
+const maxDefaultCtxSize = 8192
+
+// process represents a started server process.
+type process struct {
+	done   chan struct{}
+	err    error // set before done is closed
+	closed atomic.Bool
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// ExitError reports that LLM server exited unexpectedly.
+type ExitError struct {
+	// Err specifies the reason of exit returned by exec.Cmd.Wait.
+	Err error
+
+	// Output contains the last lines of server output (if it is logged by
+	// CmdLogger).
+	Output []string
+}
+
+func (e *ExitError) Error() string {
+	msg := fmt.Sprint(e.Err)
+	if e.Err == nil {
+		msg = "exit status 0"
+	}
+	if len(e.Output) > 0 {
+		msg += "; last output:\n\t" + strings.Join(e.Output, "\n\t")
+	}
+	return msg
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit code of the server process or -1 if the process
+// was terminated by a signal.
+func (e *ExitError) ExitCode() int {
+	var exitErr *exec.ExitError
+	if errors.As(e.Err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	if e.Err == nil {
+		return 0
+	}
+	return -1
+}
+
+// ServerStatus represents the state of LLM server reported by its health
+// endpoint.
+//
+// See: https://github.com/ggerganov/llama.cpp/blob/master/examples/server/README.md#api-endpoints
+type ServerStatus string
+
+// Statuses of LLM server.
+const (
+	// StatusUnavailable means that the server does not respond.
+	StatusUnavailable ServerStatus = "unavailable"
+	// StatusLoading means that the server is loading the model.
+	StatusLoading ServerStatus = "loading model"
+	// StatusReady means that the server is ready for completions.
+	StatusReady ServerStatus = "ok"
+	// StatusNoSlot means that the server is ready, but all slots are busy.
+	StatusNoSlot ServerStatus = "no slot available"
+	// StatusError means that the server failed to load the model.
+	StatusError ServerStatus = "error"
+)
+
 // Start starts LLM server.
 //
 // It is the caller's responsibility to close Server.
 func (s *Server) Start(ctx context.Context, modelPath string) error {
 	if s.Path == "" {
-		dir, err := os.Getwd()
+		serverPath, err := FindServer()
 		if err != nil {
-			return fmt.Errorf("cannot start a LLM server: cannot get current directory: %w", err)
+			return fmt.Errorf("cannot start a LLM server: %w", err)
 		}
-		s.Path = path.Join(dir, "./llm-server")
+		s.Path = serverPath
 	}
 	f, errServer := os.Stat(s.Path)
 	if errServer != nil {
@@ -76,19 +249,32 @@ func (s *Server) Start(ctx context.Context, modelPath string) error {
 	}
 
 	if s.Cmd == nil {
+		// address of custom command is managed by the caller
+		if port == "0" {
+			port, err = freePort(host)
+			if err != nil {
+				return fmt.Errorf("cannot start a LLM server: %w", err)
+			}
+			s.Addr = net.JoinHostPort(host, port)
+		} else if inUse(s.Addr) {
+			// otherwise completions would be sent to the foreign server
+			return fmt.Errorf("cannot start a LLM server: address %s is already in use", s.Addr)
+		}
+
 		cmdLogger := CmdLogger{
 			Log: s.Logger,
 		}
-		s.Cmd = exec.CommandContext(ctx,
-			s.Path,
-			"--host", host,
-			"--port", port,
-			"--model", modelPath,
-			"--threads", fmt.Sprint(runtime.NumCPU()),
-			"--ctx-size", fmt.Sprint(2048),
-		)
+		s.Cmd = exec.CommandContext(ctx, s.Path, s.args(host, port, modelPath)...)
 		s.Cmd.Stdout = &cmdLogger
 		s.Cmd.Stderr = &cmdLogger
+		// own process group isolates the server from signals sent to
+		// boludo by terminal, so it is stopped only by Close
+		s.Cmd.SysProcAttr = sysProcAttr()
+		s.Cmd.Cancel = func() error { return interrupt(s.Cmd.Process) }
+	}
+	if s.Cmd.WaitDelay == 0 {
+		// output pipes may be kept open by children of killed server
+		s.Cmd.WaitDelay = s.gracePeriod()
 	}
 
 	cmdErr := s.Cmd.Start()
@@ -100,47 +286,267 @@ func (s *Server) Start(ctx context.Context, modelPath string) error {
 	default:
 		return fmt.Errorf("cannot start a LLM server: %w", cmdErr)
 	}
+	proc := &process{done: make(chan struct{})}
+	s.proc = proc
+	go func(cmd *exec.Cmd) {
+		err := cmd.Wait()
+		exitErr := &ExitError{Err: err}
+		if output, ok := cmd.Stderr.(*CmdLogger); ok {
+			exitErr.Output = output.Tail()
+		}
+		proc.err = exitErr
+		close(proc.done)
+	}(s.Cmd)
+
+	if err := s.waitReady(ctx); err != nil {
+		s.Close()
+		return fmt.Errorf("cannot start a LLM server: %w", err)
+	}
+	return nil
+}
+
+// waitReady waits until the server loads the model. Check frequency is
+// limited by exponential backoff.
+func (s *Server) waitReady(ctx context.Context) error {
+	timeout := s.StartTimeout
+	if timeout == 0 {
+		timeout = 10 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	// wait for server to start. Check frequency is limited
-	i := 1
+	started := time.Now()
+	lastStatus, lastLog := ServerStatus(""), started
+	delay := 25 * time.Millisecond
 	for {
-		if ctx.Err() != nil {
-			return fmt.Errorf("cannot start a LLM server: %w", ctx.Err())
+		status, err := s.Health(ctx)
+		switch status {
+		case StatusReady, StatusNoSlot:
+			select {
+			case <-s.Done():
+				// the address was taken by other server in the meantime
+				return fmt.Errorf("server exited before loading the model: %w", s.proc.err)
+			default:
+			}
+			s.Logger.Info("LLM server is ready", slog.Duration("elapsed", time.Since(started).Round(time.Millisecond)))
+			return nil
+		case StatusError:
+			return fmt.Errorf("server failed to load the model: %w", err)
 		}
-		if ok := s.Ping(); ok {
-			break
+		if status != lastStatus || time.Since(lastLog) >= 5*time.Second {
+			s.Logger.Info("waiting for LLM server", slog.String("status", string(status)), slog.Duration("elapsed", time.Since(started).Round(time.Second)))
+			lastStatus, lastLog = status, time.Now()
 		}
-		time.Sleep(time.Duration(i*25) * time.Millisecond)
-		if i < 8 { // maximum wait time is 0.2 seconds
-			i = i * 2
+
+		select {
+		case <-s.Done():
+			return fmt.Errorf("server exited before loading the model: %w", s.proc.err)
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("server did not load the model within %s: %w", timeout, ctx.Err())
+			}
+			return ctx.Err()
+		case <-time.After(delay):
 		}
+		delay = min(2*delay, 200*time.Millisecond) // maximum wait time is 0.2 seconds
 	}
+}
 
-	return err
+// freePort returns a TCP port which is free on the host. The port may be
+// taken by other process before the server starts listening on it, but then
+// the server fails to start.
+func freePort(host string) (string, error) {
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return "", fmt.Errorf("cannot find free port: %w", err)
+	}
+	defer ln.Close()
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	return port, err
 }
 
-// Ping checks if server is running.
-func (s *Server) Ping() bool {
-	// 130 ms is an average DNS lookup time observed by Googlebot
-	// See: https://developers.google.com/speed/public-dns/docs/performance#cache_misses
-	timeoutDNS := 130 * time.Millisecond
-	conn, err := net.DialTimeout("tcp", s.Addr, timeoutDNS)
-	if err != nil || conn == nil {
+// inUse checks if some process listens on the address.
+func inUse(addr string) bool {
+	conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+	if err != nil {
 		return false
 	}
-
 	conn.Close()
 	return true
 }
 
-// Close frees all resources associated with server.
+// Health returns the state of the server reported by its health endpoint.
+// Error is returned for StatusUnavailable and StatusError.
+func (s *Server) Health(ctx context.Context) (ServerStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s/health", s.Addr), nil)
+	if err != nil {
+		return StatusUnavailable, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return StatusUnavailable, err
+	}
+	defer resp.Body.Close()
+
+	var health struct {
+		Status ServerStatus `json:"status"`
+		Error  struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&health)
+
+	switch {
+	case health.Status == StatusLoading || health.Error.Message == "Loading model":
+		return StatusLoading, nil
+	case health.Status == StatusReady || health.Status == StatusNoSlot:
+		return health.Status, nil
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotFound:
+		// older servers have no health endpoint
+		return StatusReady, nil
+	case resp.StatusCode == http.StatusServiceUnavailable:
+		return StatusLoading, nil
+	default:
+		return StatusError, fmt.Errorf("LLM server returned error: %s", resp.Status)
+	}
+}
+
+// args returns command line arguments of LLM server.
+func (s *Server) args(host, port, modelPath string) []string {
+	opts := ServerOptions{}
+	if s.Options != nil {
+		opts = *s.Options
+	}
+	if opts.CtxSize == 0 {
+		opts.CtxSize = defaultCtxSize
+		if model, err := ReadModelFile(modelPath); err == nil && model.ContextLength() > 0 {
+			modelCtxSize := model.ContextLength()
+			opts.CtxSize = int(min(modelCtxSize, maxDefaultCtxSize))
+			if modelCtxSize > maxDefaultCtxSize && s.Logger != nil {
+				s.Logger.Warn("context size is limited, set ctx-size to use the whole context of the model",
+					slog.Int("ctx_size", opts.CtxSize), slog.Uint64("model_ctx_size", modelCtxSize))
+			}
+		}
+	}
+	if opts.Threads == 0 {
+		opts.Threads = physicalCores()
+	}
+
+	slots := max(s.Slots, 1)
+	args := []string{
+		"--host", host,
+		"--port", port,
+		"--model", modelPath,
+		"--threads", fmt.Sprint(opts.Threads),
+		// context is divided between slots
+		"--ctx-size", fmt.Sprint(opts.CtxSize * slots),
+	}
+	if slots > 1 {
+		args = append(args, "--parallel", fmt.Sprint(slots))
+	}
+	if s.ContBatching {
+		args = append(args, "--cont-batching")
+	}
+	if opts.BatchSize > 0 {
+		args = append(args, "--batch-size", fmt.Sprint(opts.BatchSize))
+	}
+	if opts.MLock {
+		args = append(args, "--mlock")
+	}
+	if opts.NoMMap {
+		args = append(args, "--no-mmap")
+	}
+	if opts.RopeScaling != "" {
+		args = append(args, "--rope-scaling", opts.RopeScaling)
+	}
+	if opts.RopeScale != 0 {
+		args = append(args, "--rope-scale", fmt.Sprint(opts.RopeScale))
+	}
+	return append(args, opts.ExtraArgs...)
+}
+
+// Ping checks if server is ready for completions.
+func (s *Server) Ping() bool {
+	status, _ := s.Health(context.Background())
+	return status == StatusReady || status == StatusNoSlot
+}
+
+// Done returns a channel which is closed when the server process exits. It
+// returns nil if the server was not started.
+func (s *Server) Done() <-chan struct{} {
+	if s.proc == nil {
+		return nil
+	}
+	return s.proc.done
+}
+
+// Err returns *ExitError if the server process exited unexpectedly. It
+// returns nil while the server is running or after Close.
+func (s *Server) Err() error {
+	if s.proc == nil {
+		return nil
+	}
+	select {
+	case <-s.proc.done:
+		if s.proc.closed.Load() {
+			return nil
+		}
+		return s.proc.err
+	default:
+		return nil
+	}
+}
+
+// Close frees all resources associated with server. It interrupts the server
+// process, waits GracePeriod for its exit and kills it afterwards. Close
+// returns after the process is reaped, so it is safe to call it many times.
 func (s *Server) Close() error {
-	// FIXME: to release resources should be called: s.Wait() ???
-	if s.Cmd == nil || s.Cmd.Process == nil {
+	if s.proc == nil {
 		// server is not running
 		return nil
 	}
+	s.proc.closeOnce.Do(func() {
+		s.proc.closed.Store(true)
+		s.proc.closeErr = s.stop()
+	})
+	return s.proc.closeErr
+}
+
+// stop terminates the server process.
+func (s *Server) stop() error {
+	select {
+	case <-s.proc.done:
+		// server already exited
+		return nil
+	default:
+	}
+
+	grace := s.gracePeriod()
+	if err := interrupt(s.Cmd.Process); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		s.Logger.Debug("cannot interrupt LLM server", slog.String("error", err.Error()))
+	}
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-s.proc.done:
+		return nil
+	case <-timer.C:
+	}
 
-	// FIXME(windows): doesn't work on Windows. See: https://pkg.go.dev/os#Process.Signal
-	return s.Cmd.Process.Signal(os.Interrupt)
+	s.Logger.Warn("LLM server did not exit after interrupt, killing it", slog.Duration("grace period", grace))
+	if err := kill(s.Cmd.Process); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("cannot kill LLM server: %w", err)
+	}
+	<-s.proc.done
+	return nil
+}
+
+func (s *Server) gracePeriod() time.Duration {
+	if s.GracePeriod == 0 {
+		return 5 * time.Second
+	}
+	return s.GracePeriod
 }
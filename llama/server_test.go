@@ -2,7 +2,20 @@ package llama
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestServerStart(t *testing.T) {
@@ -14,3 +27,301 @@ func TestServerStart(t *testing.T) {
 		t.Fatalf("Start(ctx) returns error: %v", err)
 	}
 }
+
+func TestServerArgs(t *testing.T) {
+	testcases := []struct {
+		server Server
+		want   []string
+	}{
+		{Server{}, []string{"--ctx-size", "2048"}},
+		{Server{Slots: 1}, []string{"--ctx-size", "2048"}},
+		{Server{Slots: 4}, []string{"--ctx-size", "8192", "--parallel", "4"}},
+		{Server{Slots: 2, ContBatching: true}, []string{"--ctx-size", "4096", "--parallel", "2", "--cont-batching"}},
+		{Server{Slots: 2, Options: &ServerOptions{CtxSize: 8192}}, []string{"--ctx-size", "16384", "--parallel", "2"}},
+		{
+			Server{Options: &ServerOptions{CtxSize: 4096, BatchSize: 512, MLock: true, NoMMap: true, RopeScaling: "yarn", RopeScale: 4, ExtraArgs: []string{"--flash-attn"}}},
+			[]string{"--ctx-size", "4096", "--batch-size", "512", "--mlock", "--no-mmap", "--rope-scaling", "yarn", "--rope-scale", "4", "--flash-attn"},
+		},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(strings.Join(tc.want, "_"), func(t *testing.T) {
+			t.Parallel()
+			args := tc.server.args("localhost", "24114", "model.gguf")
+			got := args[slices.Index(args, "--ctx-size"):]
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("Server.args() = %q, want suffix %q", args, tc.want)
+			}
+		})
+	}
+}
+
+func TestServerArgs_Defaults(t *testing.T) {
+	model := writeModel(t, []ggufKV{{"general.architecture", "llama"}, {"llama.context_length", uint32(32768)}}, nil)
+
+	args := (&Server{}).args("localhost", "24114", model)
+	if got := args[slices.Index(args, "--ctx-size")+1]; got != "8192" {
+		t.Errorf("Server.args() uses context size %s, want %s (limited context of model)", got, "8192")
+	}
+	small := writeModel(t, []ggufKV{{"general.architecture", "llama"}, {"llama.context_length", uint32(4096)}}, nil)
+	args = (&Server{Slots: 2}).args("localhost", "24114", small)
+	if got := args[slices.Index(args, "--ctx-size")+1]; got != "8192" {
+		t.Errorf("Server.args() uses context size %s, want %s (context of model for 2 slots)", got, "8192")
+	}
+	if got := args[slices.Index(args, "--threads")+1]; got != fmt.Sprint(physicalCores()) {
+		t.Errorf("Server.args() uses %s threads, want %d", got, physicalCores())
+	}
+
+	args = (&Server{Options: &ServerOptions{Threads: 3}}).args("localhost", "24114", model)
+	if got := args[slices.Index(args, "--threads")+1]; got != "3" {
+		t.Errorf("Server.args() uses %s threads, want %s", got, "3")
+	}
+}
+
+func TestServerHealth(t *testing.T) {
+	testcases := []struct {
+		code int
+		body string
+		want ServerStatus
+	}{
+		{http.StatusOK, `{"status": "ok"}`, StatusReady},
+		{http.StatusServiceUnavailable, `{"status": "loading model"}`, StatusLoading},
+		{http.StatusServiceUnavailable, `{"error": {"code": 503, "message": "Loading model", "type": "unavailable_error"}}`, StatusLoading},
+		{http.StatusServiceUnavailable, `{"status": "no slot available", "slots_idle": 0, "slots_processing": 2}`, StatusNoSlot},
+		{http.StatusInternalServerError, `{"status": "error"}`, StatusError},
+		{http.StatusNotFound, `File Not Found`, StatusReady},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(string(tc.want), func(t *testing.T) {
+			t.Parallel()
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.code)
+				fmt.Fprint(w, tc.body)
+			}))
+			defer ts.Close()
+
+			server := Server{Addr: strings.TrimPrefix(ts.URL, "http://")}
+			got, _ := server.Health(context.TODO())
+			if got != tc.want {
+				t.Fatalf("Server.Health() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServerStart_Fail(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("shell is not available")
+	}
+	model := filepath.Join(t.TempDir(), "model.gguf")
+	if err := os.WriteFile(model, nil, 0o600); err != nil {
+		t.Fatalf("cannot write model: %v", err)
+	}
+	unused := httptest.NewServer(http.NotFoundHandler())
+	addr := strings.TrimPrefix(unused.URL, "http://")
+	unused.Close()
+
+	testcases := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"exit", "echo 'error: unsupported quantization' >&2; exit 3", "exit status 3; last output:\n\terror: unsupported quantization"},
+		{"timeout", "sleep 10", "did not load"},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			server := Server{
+				Addr:         addr,
+				Cmd:          exec.Command("sh", "-c", tc.script),
+				Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+				StartTimeout: 500 * time.Millisecond,
+			}
+			server.Path, _ = exec.LookPath("sh")
+			server.Cmd.SysProcAttr = sysProcAttr()
+			server.Cmd.Stderr = &CmdLogger{Log: server.Logger}
+			defer server.Close()
+
+			err := server.Start(context.TODO(), model)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("Server.Start() returns error %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestServerStart_Addr(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("shell is not available")
+	}
+	dir := t.TempDir()
+	model := filepath.Join(dir, "model.gguf")
+	if err := os.WriteFile(model, nil, 0o600); err != nil {
+		t.Fatalf("cannot write model: %v", err)
+	}
+	serverPath := filepath.Join(dir, "llm-server")
+	if err := os.WriteFile(serverPath, []byte("#!/bin/sh\nexit 3\n"), 0o700); err != nil {
+		t.Fatalf("cannot write server: %v", err)
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	t.Run("free port", func(t *testing.T) {
+		server := Server{Path: serverPath, Addr: "localhost:0", Logger: logger}
+		defer server.Close()
+		err := server.Start(context.TODO(), model)
+		if err == nil || !strings.Contains(err.Error(), "exit status 3") {
+			t.Fatalf("Server.Start() returns error %v, want server exit", err)
+		}
+		host, port, _ := net.SplitHostPort(server.Addr)
+		if host != "localhost" || port == "" || port == "0" {
+			t.Fatalf("Server.Addr = %q after Server.Start(), want localhost with selected port", server.Addr)
+		}
+	})
+
+	t.Run("address in use", func(t *testing.T) {
+		foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"status": "ok"}`)
+		}))
+		defer foreign.Close()
+
+		server := Server{Path: serverPath, Addr: strings.TrimPrefix(foreign.URL, "http://"), Logger: logger}
+		defer server.Close()
+		err := server.Start(context.TODO(), model)
+		if err == nil || !strings.Contains(err.Error(), "already in use") {
+			t.Fatalf("Server.Start() returns error %v, want address in use", err)
+		}
+	})
+}
+
+func TestServerErr(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("shell is not available")
+	}
+	model := filepath.Join(t.TempDir(), "model.gguf")
+	if err := os.WriteFile(model, nil, 0o600); err != nil {
+		t.Fatalf("cannot write model: %v", err)
+	}
+	// health endpoint of the server which crashes after start
+	health := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": "ok"}`)
+	}))
+	defer health.Close()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	server := Server{
+		Addr:   strings.TrimPrefix(health.URL, "http://"),
+		Cmd:    exec.Command("sh", "-c", "sleep 0.2; echo 'out of memory' >&2; exit 2"),
+		Logger: logger,
+	}
+	server.Path, _ = exec.LookPath("sh")
+	server.Cmd.Stderr = &CmdLogger{Log: logger}
+	if err := server.Start(context.TODO(), model); err != nil {
+		t.Fatalf("Server.Start() returns error: %v", err)
+	}
+	if err := server.Err(); err != nil {
+		t.Fatalf("Server.Err() = %v for running server, want nil", err)
+	}
+
+	select {
+	case <-server.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Server.Done() is not closed after server exit")
+	}
+	var exitErr *ExitError
+	if !errors.As(server.Err(), &exitErr) {
+		t.Fatalf("Server.Err() = %v, want *ExitError", server.Err())
+	}
+	if exitErr.ExitCode() != 2 || !slices.Equal(exitErr.Output, []string{"out of memory"}) {
+		t.Fatalf("Server.Err() = %q (code %d), want output %q (code %d)", exitErr.Output, exitErr.ExitCode(), "out of memory", 2)
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("shell is not available")
+	}
+	model := filepath.Join(t.TempDir(), "model.gguf")
+	if err := os.WriteFile(model, nil, 0o600); err != nil {
+		t.Fatalf("cannot write model: %v", err)
+	}
+	health := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": "ok"}`)
+	}))
+	t.Cleanup(health.Close)
+
+	testcases := []struct {
+		name   string
+		script string
+	}{
+		{"interrupt", "sleep 10"},
+		{"kill", "trap '' INT; sleep 10"},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			server := Server{
+				Addr:        strings.TrimPrefix(health.URL, "http://"),
+				Cmd:         exec.Command("sh", "-c", tc.script),
+				Logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+				GracePeriod: 200 * time.Millisecond,
+			}
+			server.Path, _ = exec.LookPath("sh")
+			server.Cmd.SysProcAttr = sysProcAttr()
+			server.Cmd.Stderr = &CmdLogger{Log: server.Logger}
+			if err := server.Start(context.TODO(), model); err != nil {
+				t.Fatalf("Server.Start() returns error: %v", err)
+			}
+
+			closed := make(chan error, 1)
+			go func() { closed <- server.Close() }()
+			select {
+			case err := <-closed:
+				if err != nil {
+					t.Fatalf("Server.Close() returns error: %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Server.Close() does not return")
+			}
+			select {
+			case <-server.Done():
+			default:
+				t.Fatalf("Server.Done() is not closed after Server.Close()")
+			}
+			if err := server.Close(); err != nil {
+				t.Fatalf("second Server.Close() returns error: %v", err)
+			}
+			if err := server.Err(); err != nil {
+				t.Fatalf("Server.Err() = %v after Server.Close(), want nil", err)
+			}
+		})
+	}
+}
+
+func TestFindServer(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("shell is not available")
+	}
+	empty, withServer := t.TempDir(), t.TempDir()
+	want := filepath.Join(withServer, "llama-server")
+	if err := os.WriteFile(want, []byte("#!/bin/sh\n"), 0o700); err != nil {
+		t.Fatalf("cannot write server: %v", err)
+	}
+	t.Setenv("PATH", empty)
+
+	got, err := FindServer(empty, withServer)
+	if err != nil {
+		t.Fatalf("FindServer() returns error: %v", err)
+	}
+	if got != want {
+		t.Fatalf("FindServer() = %q, want %q", got, want)
+	}
+
+	_, err = FindServer(empty)
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(empty, "llm-server")) || !strings.Contains(err.Error(), "$PATH") {
+		t.Fatalf("FindServer() returns error %v, want list of tried locations", err)
+	}
+}
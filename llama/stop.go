@@ -0,0 +1,67 @@
+package llama
+
+import (
+	"strings"
+)
+
+// stopFilter truncates streamed text at the first occurrence of any stop
+// string. It is needed, because LLM server can miss stop strings split
+// between tokens.
+//
+// Text which may be the beginning of a stop string is held back until the
+// next chunk of text decides about it.
+type stopFilter struct {
+	stop    []string
+	pending string
+	stopped bool
+
+	// matched is the stop string found in the text
+	matched string
+}
+
+// push adds the next chunk of text and returns the text safe to emit. It
+// reports whether a stop string was found.
+func (f *stopFilter) push(s string) (string, bool) {
+	if f.stopped {
+		return "", true
+	}
+	f.pending += s
+
+	cut := -1
+	for _, stop := range f.stop {
+		if stop == "" {
+			continue
+		}
+		if i := strings.Index(f.pending, stop); i >= 0 && (cut < 0 || i < cut) {
+			cut = i
+			f.matched = stop
+		}
+	}
+	if cut >= 0 {
+		out := f.pending[:cut]
+		f.pending = ""
+		f.stopped = true
+		return out, true
+	}
+
+	// hold back the longest suffix which is a prefix of some stop string
+	hold := 0
+	for _, stop := range f.stop {
+		for n := min(len(stop)-1, len(f.pending)); n > hold; n-- {
+			if strings.HasSuffix(f.pending, stop[:n]) {
+				hold = n
+				break
+			}
+		}
+	}
+	out := f.pending[:len(f.pending)-hold]
+	f.pending = f.pending[len(f.pending)-hold:]
+	return out, false
+}
+
+// flush returns the text held back at the end of the stream.
+func (f *stopFilter) flush() string {
+	out := f.pending
+	f.pending = ""
+	return out
+}
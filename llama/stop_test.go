@@ -0,0 +1,41 @@
+package llama
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStopFilter(t *testing.T) {
+	testcases := []struct {
+		stop   []string
+		chunks []string
+		want   string
+	}{
+		{nil, []string{"Hello", " world"}, "Hello world"},
+		{[]string{"###"}, []string{"Hello", " world"}, "Hello world"},
+		{[]string{"###"}, []string{"Hello", "\n###", " Instruction"}, "Hello\n"},
+		{[]string{"###"}, []string{"Hello\n#", "#", "# Instruction"}, "Hello\n"},
+		{[]string{"###"}, []string{"Hello #", "1"}, "Hello #1"},
+		{[]string{"<|im_end|>", "</s>"}, []string{"Fine.</", "s><|im_end|>"}, "Fine."},
+		{[]string{"END"}, []string{"The E"}, "The E"},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(strings.Join(tc.chunks, "|"), func(t *testing.T) {
+			t.Parallel()
+			f := stopFilter{stop: tc.stop}
+			got := strings.Builder{}
+			for _, chunk := range tc.chunks {
+				out, stopped := f.push(chunk)
+				got.WriteString(out)
+				if stopped {
+					break
+				}
+			}
+			got.WriteString(f.flush())
+			if got.String() != tc.want {
+				t.Fatalf("stopFilter{%q} returns %q, want %q", tc.stop, got.String(), tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package llama
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// StopReason represents the reason why the generation ended.
+type StopReason string
+
+// Reasons of ending the generation.
+const (
+	// StopEOS means that the model ended the answer.
+	StopEOS StopReason = "eos"
+	// StopWord means that a stop string was generated.
+	StopWord StopReason = "word"
+	// StopLimit means that the limit of generated tokens was reached.
+	StopLimit StopReason = "limit"
+)
+
+// Result represents metadata of a finished completion.
+type Result struct {
+	// TokensEvaluated specifies the number of prompt tokens.
+	TokensEvaluated int
+	// TokensPredicted specifies the number of generated tokens.
+	TokensPredicted int
+	// TokensPerSecond specifies the generation speed.
+	TokensPerSecond float64
+
+	// StopReason specifies why the generation ended. It is empty if the
+	// generation was interrupted.
+	StopReason StopReason
+	// StoppingWord specifies the stop string which ended the generation.
+	StoppingWord string
+	// Truncated reports whether the prompt was truncated to fit the context.
+	Truncated bool
+
+	// Settings contains generation settings reported by the LLM server.
+	Settings map[string]any
+}
+
+// Completion represents the whole answer of the model.
+type Completion struct {
+	Text   string
+	Result Result
+}
+
+// chunk represents a part of the streamed answer.
+type chunk struct {
+	content string
+
+	// final is not nil for the last chunk of the stream
+	final *Result
+}
+
+// Stream represents a completion streamed from the LLM server.
+//
+// Tokens are read with Next and Text:
+//
+//	for stream.Next() {
+//		fmt.Print(stream.Text())
+//	}
+//	if err := stream.Err(); err != nil {
+//		...
+//	}
+type Stream struct {
+	// decode returns the next chunk of the answer or io.EOF at the end of
+	// the stream
+	decode func() (chunk, error)
+	body   io.Closer
+	filter stopFilter
+
+	text   string
+	result Result
+	err    error
+	done   bool
+	closed bool
+}
+
+// newStream returns a Stream which reads chunks with decode function and
+// truncates the answer at stop strings.
+func newStream(body io.Closer, decode func() (chunk, error), stop []string) *Stream {
+	return &Stream{
+		decode: decode,
+		body:   body,
+		filter: stopFilter{stop: stop},
+	}
+}
+
+// NewTextStream returns a Stream with given parts of the answer and its
+// metadata. It is useful for Completer implementations which do not stream
+// the answer and for tests.
+func NewTextStream(parts []string, result Result) *Stream {
+	i := 0
+	decode := func() (chunk, error) {
+		if i == len(parts) {
+			return chunk{final: &result}, nil
+		}
+		i++
+		return chunk{content: parts[i-1]}, nil
+	}
+	return newStream(io.NopCloser(nil), decode, nil)
+}
+
+// Next advances the stream to the next part of the answer, which will then
+// be available through the Text method. It returns false when the stream
+// ends, either by reaching the end of the answer or an error.
+func (s *Stream) Next() bool {
+	for {
+		if s.done {
+			s.text = s.filter.flush()
+			if s.text != "" {
+				return true
+			}
+			s.Close()
+			return false
+		}
+
+		c, err := s.decode()
+		switch {
+		case errors.Is(err, io.EOF):
+			s.done = true
+			continue
+		case err != nil:
+			s.err = err
+			s.done = true
+			continue
+		}
+
+		if c.final != nil {
+			s.result = *c.final
+			s.done = true
+		}
+		if c.content == "" {
+			continue
+		}
+
+		// server can miss stop strings split between tokens
+		text, stopped := s.filter.push(c.content)
+		if stopped {
+			s.result.StopReason = StopWord
+			s.result.StoppingWord = s.filter.matched
+			s.done = true
+		}
+		if text != "" {
+			s.text = text
+			return true
+		}
+	}
+}
+
+// Text returns the most recent part of the answer generated by a call to Next.
+func (s *Stream) Text() string {
+	return s.text
+}
+
+// Err returns the first error that was encountered by the Stream.
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Result returns metadata of the completion. It is complete after Next
+// returns false.
+func (s *Stream) Result() Result {
+	return s.result
+}
+
+// Close releases the connection with the LLM server. It interrupts the
+// generation if the stream has not ended yet.
+func (s *Stream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.done = true
+	return s.body.Close()
+}
+
+// tokens returns a channel with parts of the answer. The channel is closed
+// at the end of the stream or when the context is cancelled.
+func (s *Stream) tokens(ctx context.Context) chan string {
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		defer s.Close()
+
+		for s.Next() {
+			select {
+			case ch <- s.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// complete returns a channel with parts of the answer streamed by the
+// Completer.
+func complete(ctx context.Context, c Completer, p Prompt) (chan string, error) {
+	stream, err := c.CompleteStream(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return stream.tokens(ctx), nil
+}
+
+// collect reads the whole answer from the stream and closes it.
+func collect(ctx context.Context, stream *Stream) (Completion, error) {
+	defer stream.Close()
+
+	text := strings.Builder{}
+	for stream.Next() {
+		text.WriteString(stream.Text())
+	}
+	if ctx.Err() != nil {
+		return Completion{}, fmt.Errorf("could not complete: %w", ctx.Err())
+	}
+	if err := stream.Err(); err != nil {
+		return Completion{}, fmt.Errorf("could not complete: %w", err)
+	}
+	return Completion{Text: text.String(), Result: stream.Result()}, nil
+}
+
+// maxEventSize limits the size of a single server-sent event. Final event can
+// be large, because it contains the whole prompt.
+const maxEventSize = 8 << 20
+
+// newLineScanner returns a scanner of lines streamed by the LLM server.
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxEventSize)
+	return scanner
+}
+
+// scanErr returns the error which ended scanning of the streamed answer. The
+// stream ending without the final response is reported as
+// io.ErrUnexpectedEOF.
+func scanErr(scanner *bufio.Scanner) error {
+	switch err := scanner.Err(); {
+	case errors.Is(err, bufio.ErrTooLong):
+		return fmt.Errorf("server event exceeds %d bytes: %w", maxEventSize, err)
+	case err != nil:
+		return fmt.Errorf("cannot read server events: %w", err)
+	}
+	return fmt.Errorf("stream ended before the end of the answer: %w", io.ErrUnexpectedEOF)
+}
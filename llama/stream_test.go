@@ -0,0 +1,242 @@
+package llama
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	testcases := []struct {
+		name   string
+		stop   []string
+		chunks []chunk
+		want   string
+		result Result
+	}{
+		{
+			"final",
+			nil,
+			[]chunk{{content: "Hello"}, {content: " world"}, {final: &Result{TokensPredicted: 2, StopReason: StopEOS}}},
+			"Hello world",
+			Result{TokensPredicted: 2, StopReason: StopEOS},
+		},
+		{
+			"final with content",
+			nil,
+			[]chunk{{content: "Hello"}, {content: " world", final: &Result{StopReason: StopLimit}}},
+			"Hello world",
+			Result{StopReason: StopLimit},
+		},
+		{
+			"stop string",
+			[]string{"###"},
+			[]chunk{{content: "Hello\n#"}, {content: "##"}, {content: " Instruction"}},
+			"Hello\n",
+			Result{StopReason: StopWord, StoppingWord: "###"},
+		},
+		{
+			"held back",
+			[]string{"###"},
+			[]chunk{{content: "Hello #"}},
+			"Hello #",
+			Result{},
+		},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			chunks := tc.chunks
+			decode := func() (chunk, error) {
+				if len(chunks) == 0 {
+					return chunk{}, io.EOF
+				}
+				c := chunks[0]
+				chunks = chunks[1:]
+				return c, nil
+			}
+			s := newStream(io.NopCloser(nil), decode, tc.stop)
+
+			got := strings.Builder{}
+			for s.Next() {
+				got.WriteString(s.Text())
+			}
+			if s.Err() != nil {
+				t.Fatalf("Stream.Err() = %v, want nil", s.Err())
+			}
+			if got.String() != tc.want {
+				t.Fatalf("Stream returns %q, want %q", got.String(), tc.want)
+			}
+			if !reflect.DeepEqual(s.Result(), tc.result) {
+				t.Fatalf("Stream.Result() = %+v, want %+v", s.Result(), tc.result)
+			}
+		})
+	}
+}
+
+func TestClientCompleteStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `data: {"content":"Hi","stop":false}`)
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, `data: {"content":"!","stop":false}`)
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, `data: {"content":"","stop":true,"tokens_evaluated":12,"tokens_predicted":2,"truncated":true,"stopped_limit":true,"timings":{"predicted_per_second":25.5},"generation_settings":{"n_ctx":2048}}`)
+	}))
+	defer server.Close()
+
+	client := Client{Addr: strings.TrimPrefix(server.URL, "http://")}
+	stream, err := client.CompleteStream(context.TODO(), Prompt{})
+	if err != nil {
+		t.Fatalf("client.CompleteStream() returns error: %v", err)
+	}
+	defer stream.Close()
+
+	got := strings.Builder{}
+	for stream.Next() {
+		got.WriteString(stream.Text())
+	}
+	if got.String() != "Hi!" {
+		t.Fatalf("client.CompleteStream() = %q, want %q", got.String(), "Hi!")
+	}
+	want := Result{
+		TokensEvaluated: 12,
+		TokensPredicted: 2,
+		TokensPerSecond: 25.5,
+		StopReason:      StopLimit,
+		Truncated:       true,
+		Settings:        map[string]any{"n_ctx": float64(2048)},
+	}
+	if !reflect.DeepEqual(stream.Result(), want) {
+		t.Fatalf("client.CompleteStream().Result() = %+v, want %+v", stream.Result(), want)
+	}
+}
+
+func TestClientCompleteStream_Errors(t *testing.T) {
+	testcases := []struct {
+		name    string
+		handler func(w http.ResponseWriter)
+		want    error
+	}{
+		{
+			"malformed event",
+			func(w http.ResponseWriter) {
+				fmt.Fprintln(w, `data: {"content":"Hi","stop":false}`)
+				fmt.Fprintln(w, `data: {"content":`)
+			},
+			nil,
+		},
+		{
+			"connection reset",
+			func(w http.ResponseWriter) {
+				fmt.Fprintln(w, `data: {"content":"Hi","stop":false}`)
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			},
+			nil,
+		},
+		{
+			"error event",
+			func(w http.ResponseWriter) {
+				fmt.Fprintln(w, `data: {"content":"Hi","stop":false}`)
+				fmt.Fprintln(w, `error: {"content":"slot unavailable"}`)
+			},
+			serverError{Message: `{"content":"slot unavailable"}`, Content: "slot unavailable"},
+		},
+		{
+			"error object",
+			func(w http.ResponseWriter) {
+				fmt.Fprintln(w, `data: {"content":"Hi","stop":false}`)
+				fmt.Fprintln(w, `data: {"error":{"code":500,"message":"out of memory"}}`)
+			},
+			serverError{Code: 500, Message: "out of memory"},
+		},
+		{
+			"too long event",
+			func(w http.ResponseWriter) {
+				fmt.Fprintln(w, `data: {"content":"Hi","stop":false}`)
+				fmt.Fprintf(w, "data: {\"content\":%q,\"stop\":false}\n", strings.Repeat("a", maxEventSize))
+			},
+			bufio.ErrTooLong,
+		},
+		{
+			"unexpected end",
+			func(w http.ResponseWriter) {
+				fmt.Fprintln(w, `data: {"content":"Hi","stop":false}`)
+			},
+			io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tc.handler(w)
+			}))
+			defer server.Close()
+
+			client := Client{
+				Addr:   strings.TrimPrefix(server.URL, "http://"),
+				Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			}
+			stream, err := client.CompleteStream(context.TODO(), Prompt{})
+			if err != nil {
+				t.Fatalf("client.CompleteStream() returns error: %v", err)
+			}
+			defer stream.Close()
+
+			got := strings.Builder{}
+			for stream.Next() {
+				got.WriteString(stream.Text())
+			}
+			if got.String() != "Hi" {
+				t.Errorf("client.CompleteStream() = %q, want %q", got.String(), "Hi")
+			}
+			if stream.Err() == nil {
+				t.Fatalf("client.CompleteStream().Err() = nil, want error")
+			}
+			if tc.want != nil && !errors.Is(stream.Err(), tc.want) {
+				t.Fatalf("client.CompleteStream().Err() = %v, want %v", stream.Err(), tc.want)
+			}
+		})
+	}
+}
+
+func TestClientCompleteStream_LongEvent(t *testing.T) {
+	long := strings.Repeat("a", 100<<10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "data: {\"content\":%q,\"stop\":false}\n", long)
+		fmt.Fprintln(w, `data: {"content":"","stop":true}`)
+	}))
+	defer server.Close()
+
+	client := Client{
+		Addr:   strings.TrimPrefix(server.URL, "http://"),
+		Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	stream, err := client.CompleteStream(context.TODO(), Prompt{})
+	if err != nil {
+		t.Fatalf("client.CompleteStream() returns error: %v", err)
+	}
+	defer stream.Close()
+
+	got := strings.Builder{}
+	for stream.Next() {
+		got.WriteString(stream.Text())
+	}
+	if stream.Err() != nil {
+		t.Fatalf("client.CompleteStream().Err() = %v, want nil", stream.Err())
+	}
+	if got.String() != long {
+		t.Fatalf("client.CompleteStream() returns %d bytes, want %d", got.Len(), len(long))
+	}
+}